@@ -17,11 +17,15 @@ limitations under the License.
 package main
 
 import (
+	"encoding/json"
 	"log"
 	"os"
 	"github.com/knative/eventing-sources/pkg/adapter/amqpsource"
+	"github.com/knative/pkg/logging"
+	"github.com/knative/pkg/signals"
 	"go.uber.org/zap"
 	"strconv"
+	"time"
 )
 
 func getRequiredEnv(envKey string) string {
@@ -32,6 +36,58 @@ func getRequiredEnv(envKey string) string {
 	return val
 }
 
+// getListEnv returns the values of an optional environment variable holding
+// a JSON array of strings.
+func getListEnv(envKey string) []string {
+	val, _ := os.LookupEnv(envKey)
+	if val == "" {
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal([]byte(val), &list); err != nil {
+		log.Fatalf("bad %s value: %v", envKey, err)
+	}
+	return list
+}
+
+// getIntEnv returns the value of an optional integer environment variable,
+// or 0 if it is not defined.
+func getIntEnv(envKey string) int {
+	val, _ := os.LookupEnv(envKey)
+	if val == "" {
+		return 0
+	}
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		log.Fatalf("bad %s value: %v", envKey, err)
+	}
+	return i
+}
+
+// getOptionalIntEnv returns the value of an optional integer environment
+// variable, or nil if it is not defined.
+func getOptionalIntEnv(envKey string) *int {
+	if val, _ := os.LookupEnv(envKey); val == "" {
+		return nil
+	}
+	i := getIntEnv(envKey)
+	return &i
+}
+
+// getDurationEnv returns the value of an optional duration environment
+// variable, or 0 if it is not defined.
+func getDurationEnv(envKey string) time.Duration {
+	val, _ := os.LookupEnv(envKey)
+	if val == "" {
+		return 0
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		log.Fatalf("bad %s value: %v", envKey, err)
+	}
+	return d
+}
+
 var (
 	sink      string
 	source    string
@@ -39,10 +95,11 @@ var (
 )
 
 func main() {
-	logger, err := zap.NewProduction()
-	if err != nil {
-		log.Fatalf("unable to create logger: %v", err)
-	}
+	// The zap configuration and level from config-logging, passed by the
+	// controller.  A missing or malformed configuration falls back to
+	// knative's production defaults.
+	sugared, _ := logging.NewLogger(os.Getenv("K_LOGGING_CONFIG"), os.Getenv("K_LOGGING_LEVEL"))
+	logger := sugared.Desugar()
 
 	sink = getRequiredEnv("SINK_URI")
 	source = getRequiredEnv("AMQP_URI")
@@ -52,17 +109,92 @@ func main() {
 	}
 
 	credsPath, _ := os.LookupEnv("AMQP_CREDENTIALS")
+	deadLetter, _ := os.LookupEnv("AMQP_DEAD_LETTER_URI")
+
+	permanentFailure, err := amqpsource.ParseDisposition(os.Getenv("AMQP_PERMANENT_FAILURE"))
+	if err != nil {
+		log.Fatalf("bad AMQP_PERMANENT_FAILURE value: %v", err)
+	}
+	transientFailure, err := amqpsource.ParseDisposition(os.Getenv("AMQP_TRANSIENT_FAILURE"))
+	if err != nil {
+		log.Fatalf("bad AMQP_TRANSIENT_FAILURE value: %v", err)
+	}
+
+	deliveryMode, err := amqpsource.ParseDeliveryMode(os.Getenv("AMQP_DELIVERY_MODE"))
+	if err != nil {
+		log.Fatalf("bad AMQP_DELIVERY_MODE value: %v", err)
+	}
+
+	specVersion, err := amqpsource.ParseSpecVersion(os.Getenv("CE_SPEC_VERSION"))
+	if err != nil {
+		log.Fatalf("bad CE_SPEC_VERSION value: %v", err)
+	}
+
+	contentMode, err := amqpsource.ParseContentMode(os.Getenv("CE_CONTENT_MODE"))
+	if err != nil {
+		log.Fatalf("bad CE_CONTENT_MODE value: %v", err)
+	}
+
+	var metricsAddr, healthAddr string
+	if port := os.Getenv("METRICS_PORT"); port != "" {
+		metricsAddr = ":" + port
+	}
+	if port := os.Getenv("HEALTH_PORT"); port != "" {
+		healthAddr = ":" + port
+	}
 
 	a := amqpsource.Adapter{
-		SourceURI: source,
-		SinkURI:   sink,
-		Credit:    credit,
-		CredsPath: credsPath,
+		SourceURI:        source,
+		SinkURI:          sink,
+		Credit:           credit,
+		CredsPath:        credsPath,
+		DeadLetterURI:    deadLetter,
+		DeliveryMode:     deliveryMode,
+		GroupKey:         os.Getenv("AMQP_GROUP_KEY"),
+		MaxGroups:        getIntEnv("AMQP_MAX_GROUPS"),
+		BatchSize:        getIntEnv("AMQP_BATCH_SIZE"),
+		BatchWindow:      getDurationEnv("AMQP_BATCH_WINDOW"),
+		PropertyAllow:    getListEnv("AMQP_PROPERTIES_ALLOW"),
+		PropertyDeny:     getListEnv("AMQP_PROPERTIES_DENY"),
+		PermanentFailure: permanentFailure,
+		TransientFailure: transientFailure,
+		GracePeriod:      getDurationEnv("AMQP_GRACE_PERIOD"),
+		SpecVersion:      specVersion,
+		SourceName:       os.Getenv("SOURCE_NAME"),
+		SourceNamespace:  os.Getenv("SOURCE_NAMESPACE"),
+		MetricsAddr:      metricsAddr,
+		HealthAddr:       healthAddr,
+		TracingEndpoint:  os.Getenv("TRACING_ENDPOINT"),
+		Logger:           sugared,
+		ContentMode:      contentMode,
+		SinkClient: amqpsource.SinkClientConfig{
+			Timeout:             getDurationEnv("SINK_TIMEOUT"),
+			MaxIdleConns:        getIntEnv("SINK_MAX_IDLE_CONNS"),
+			MaxIdleConnsPerHost: getIntEnv("SINK_MAX_IDLE_CONNS_PER_HOST"),
+			IdleConnTimeout:     getDurationEnv("SINK_IDLE_CONN_TIMEOUT"),
+			DisableHTTP2:        os.Getenv("SINK_DISABLE_HTTP2") == "true",
+			TLSPath:             os.Getenv("SINK_TLS_PATH"),
+		},
+		Retry: amqpsource.RetryPolicy{
+			MaxAttempts:    getIntEnv("AMQP_RETRY_MAX_ATTEMPTS"),
+			InitialBackoff: getDurationEnv("AMQP_RETRY_INITIAL_BACKOFF"),
+			MaxBackoff:     getDurationEnv("AMQP_RETRY_MAX_BACKOFF"),
+			JitterPercent:  getOptionalIntEnv("AMQP_RETRY_JITTER_PERCENT"),
+			AttemptTimeout: getDurationEnv("AMQP_RETRY_ATTEMPT_TIMEOUT"),
+		},
 	}
 
-	logger.Info("Starting AMQP Adapter. %v", zap.Reflect("adapter", a))
+	// The adapter's configuration is not logged whole: the URIs may hold
+	// passwords.
+	logger.Info("Starting AMQP Adapter",
+		zap.String("source", amqpsource.RedactURL(source)),
+		zap.String("sink", amqpsource.RedactURL(sink)),
+		zap.Int("credit", credit))
+
+	// Stop gracefully on SIGTERM or SIGINT.
+	stopCh := signals.SetupSignalHandler()
 
-	err = a.Start()
+	err = a.Start(stopCh)
 	if err != nil {
 		logger.Fatal("Failed to start the adapter", zap.Error(err))
 	}
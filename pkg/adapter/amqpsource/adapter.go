@@ -19,13 +19,11 @@ package amqpsource
 import (
 	"context"
 	"fmt"
-	"github.com/knative/pkg/logging"
 	"go.uber.org/zap"
 	"io/ioutil"
-	"io"
 	"net/http"
 	"os"
-	"log"
+	"io"
 	"strings"
 	"crypto/tls"
 	"crypto/x509"
@@ -33,20 +31,19 @@ import (
 	"net"
 	"encoding/base64"
 	"encoding/json"
+	"sync"
+	"time"
 
 	"github.com/knative/pkg/cloudevents"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 
 	// Imports the Qpid AMQP Go client
 	"qpid.apache.org/amqp"
 	"qpid.apache.org/electron"
 )
 
-type amqpBodyReader struct {
-	body string
-	offset int
-}
-
-
 type Adapter struct {
 	// URI-eske connection and address info to attach to the AMQP endpoint
 	// (confusingly also a "source") via AMQP or AMQPS protocol.
@@ -58,127 +55,339 @@ type Adapter struct {
 	Credit int
 	// Optional connect-config configuration, including password/TLS secrets
 	CredsPath string
+	// Application property names to map to CloudEvent extensions.  Empty
+	// maps all properties.
+	PropertyAllow []string
+	// Application property names never mapped to CloudEvent extensions.
+	PropertyDeny []string
+	// Outcome for messages the sink rejects with a 4xx status, or that
+	// cannot be converted to an event.  Defaults to Reject.  Unused if
+	// DeadLetterURI is set.
+	PermanentFailure Disposition
+	// Outcome for messages the sink fails with a 5xx, 408 or 429 status, or
+	// does not respond to.  Defaults to Release.  Unused if DeadLetterURI
+	// is set.
+	TransientFailure Disposition
+	// Retry policy for delivering events to the sink.
+	Retry RetryPolicy
+	// Optional dead letter address for messages the sink does not accept:
+	// an AMQP address on the source's connection, or an AMQP URI.
+	DeadLetterURI string
+	// Whether messages are delivered to the sink one at a time,
+	// concurrently, or one at a time per group.  Defaults to Ordered.
+	DeliveryMode DeliveryMode
+	// Application property holding the group of a message in Grouped
+	// mode.  Empty uses the AMQP group-id.
+	GroupKey string
+	// Maximum number of groups delivered concurrently in Grouped mode.
+	// Defaults to, and is limited by, Credit.
+	MaxGroups int
+	// CloudEvents spec version of the events sent to the sink.  Defaults
+	// to SpecVersion10.
+	SpecVersion SpecVersion
+	// Whether events are sent to the sink in binary or structured content
+	// mode.  Defaults to Binary.
+	ContentMode ContentMode
+	// HTTP client settings for deliveries to the sink.
+	SinkClient SinkClientConfig
+	// Maximum number of messages whose events are posted to the sink as
+	// one application/cloudevents-batch+json request.  0 or 1 disables
+	// batching.
+	BatchSize int
+	// Longest time a message waits for its batch to fill up.  Defaults to
+	// 100 milliseconds.
+	BatchWindow time.Duration
+	// Time allowed for in-flight deliveries to complete on shutdown.
+	// Defaults to 20 seconds.
+	GracePeriod time.Duration
+	// Name and namespace of the AmqpSource, used to label metrics.
+	SourceName      string
+	SourceNamespace string
+	// Optional address, e.g. ":9090", to serve Prometheus metrics on at
+	// /metrics.
+	MetricsAddr string
+	// Optional address, e.g. ":8080", to serve the /healthz and /readyz
+	// probes on.
+	HealthAddr string
+	// Optional OTLP/HTTP collector URL, e.g. "http://otel-collector:4318",
+	// to export trace spans to.
+	TracingEndpoint string
+	// Logger for the adapter.  Nil discards log entries.
+	Logger *zap.SugaredLogger
 	// The canonical name for the CloudEvents "source" Context Attribute.
 	SpecSource string
 	// The CA root(s) in pem format to authenticate the connection
 	RootCA []byte
+	// Optional client certificate to authenticate to the broker with.
+	ClientCert *tls.Certificate
+
+	stopCh   <-chan struct{}
+	abortCtx context.Context
+	abort    context.CancelFunc
+
+	clientOnce sync.Once
+	client     *http.Client
+	clientErr  error
+
+	metrics        *metrics
+	linksMu        sync.Mutex
+	receiver       electron.Receiver
+	deadLetter     electron.Sender
+	tracer         trace.Tracer
+	tracerProvider *sdktrace.TracerProvider
+
+	config         *ConnectConfig
+	deadLetterConn electron.Connection
+
+	// settleFunc, if set, settles deliveries in place of their own
+	// methods.  For tests, which cannot make an electron.ReceivedMessage.
+	settleFunc func(*electron.ReceivedMessage, Disposition) error
+}
+
+const defaultGracePeriod = 20 * time.Second
+
+// deliveryContext returns the context for deliveries to the sink, which is
+// cancelled when the shutdown grace period expires.
+func (a *Adapter) deliveryContext() context.Context {
+	if a.abortCtx == nil {
+		return context.Background()
+	}
+	return a.abortCtx
+}
+
+// abortAfterGrace waits for a stop request, then cancels the deliveries
+// still in flight once the grace period has expired.
+func (a *Adapter) abortAfterGrace() {
+	<-a.stopCh
+	grace := a.GracePeriod
+	if grace <= 0 {
+		grace = defaultGracePeriod
+	}
+	a.logger().Infow("Shutting down", "gracePeriod", grace.String())
+	time.AfterFunc(grace, a.abort)
 }
 
-var msgCount = int64(0)
+// aborted returns true once the shutdown grace period has expired.
+func (a *Adapter) aborted() bool {
+	return a.abortCtx != nil && a.abortCtx.Err() != nil
+}
 
 
-// Run creates a single AMQP connection/session/receiver to read messages, converts each
-// message to a cloudevent and delivers it to the sink.
-func (a *Adapter) Start() error {
-	// logger := logging.FromContext(context.TODO())
-	// TODO: set up signals so we handle the first shutdown signal gracefully
+// Run creates an AMQP connection/session/receiver to read messages, converts each
+// message to a cloudevent and delivers it to the sink.  If the connection fails it
+// reconnects, to the same or a failover broker.
+//
+// When stopCh is closed, Start stops receiving, finishes in-flight deliveries within
+// GracePeriod, releases undelivered messages, closes the connection and returns nil.
+func (a *Adapter) Start(stopCh <-chan struct{}) error {
+	logger := a.logger()
+	a.stopCh = stopCh
+	a.abortCtx, a.abort = context.WithCancel(context.Background())
+	defer a.abort()
+	go a.abortAfterGrace()
 
 	// Use Kubernetes PODNAME-uuid as descriptive and unique AMQP container name:
 	container := electron.NewContainer(fmt.Sprintf("%s", os.Getenv("HOSTNAME")))
 
 	// Create connect url from address URI + connect-config data + AMQP defaults
-	u, err := url.Parse(a.SourceURI)
-	fatalIf(err)
-	a.applyConfig(u)
-	err = amqp.UpdateURL(u)
-	fatalIf(err)
+	u, err := parseURL(a.SourceURI)
+	if err != nil {
+		return fmt.Errorf("bad AMQP source address: %s", err)
+	}
+	if err = a.applyConfig(u); err != nil {
+		return err
+	}
+	if err = amqp.UpdateURL(u); err != nil {
+		return fmt.Errorf("bad AMQP source address: %s", err)
+	}
 
-	a.SpecSource = fmt.Sprintf("%s://%s:%s/%s", u.Scheme, u.Hostname(), u.Port(), u.Path)
-	log.Printf("Dial")
-	tcpconn, err := a.dial(u)
-	fatalIf(err)
-	amqpconn, err := container.Connection(tcpconn)
-	fatalIf(err)
-
-	addr := strings.TrimPrefix(u.Path, "/")
-	opts := []electron.LinkOption{electron.Source(addr)}
-	opts = append(opts, electron.Capacity(int(a.Credit)), electron.Prefetch(true))
-	log.Printf("Create receiver")
-	r, err := amqpconn.Receiver(opts...)
-	fatalIf(err)
-	log.Printf("Receive")
-	for {
-		if rm, err := r.Receive(); err == nil {
-			log.Printf("Got message: %s", rm.Message)
-			err = a.postMessage(&rm.Message)
-			if (err == nil) {
-				log.Printf("Message posted")
-				rm.Accept()
-			} else {
-				log.Printf("Failed to post message: %s", err)
-				rm.Reject()
-			}
-		} else {
-			log.Printf("Failed to receive: %s", err)
-			fatalIf(err)
-		}
+	if _, err := a.sinkClient(); err != nil {
+		return err
+	}
+	if a.MetricsAddr != "" {
+		a.metrics = newMetrics(a.SourceName, a.SourceNamespace)
+		a.metrics.serve(a.MetricsAddr, a.logger())
+	}
+	if a.HealthAddr != "" {
+		a.serveHealth(a.HealthAddr)
 	}
-	//amqpconn.Close(nil)  defer... or irrelevant?
-	log.Printf("NOTREACHED reached")
+	if err := a.setupTracing(); err != nil {
+		return err
+	}
+	defer a.shutdownTracing()
+
+	a.SpecSource = fmt.Sprintf("%s://%s:%s/%s", u.Scheme, u.Hostname(), u.Port(), u.Path)
+	logger.Infow("Starting", "source", redactedURL(u), "sink", RedactURL(a.SinkURI))
+	a.connectLoop(container, u)
+	logger.Info("Stopped")
 	return nil
 }
 
-func (a *Adapter) postMessage(m *amqp.Message) error {
-	logger := logging.FromContext(context.TODO())
+// postMessage converts m and posts its event(s) to the sink.  The events of
+// a batch message are posted as a single batch request, so a failure cannot
+// leave some of them delivered when the message is redelivered.
+func (a *Adapter) postMessage(parent context.Context, m *amqp.Message) error {
+	events, err := a.messageEvents(m)
+	if err != nil {
+		return err
+	}
+	switch len(events) {
+	case 0:
+		// An empty batch.
+		return nil
+	case 1:
+		return a.postEvent(parent, events[0].ctx, events[0].data)
+	default:
+		return a.postBatch(parent, events)
+	}
+}
 
-	// TODO: check for existing CloudEvents headers to see if we are just forwarding an existing event.
-	// The following code creates a new CloudEvents event from an arbitrary AMQP message.
+// messageEvents converts m to the event(s) to post to the sink.
+func (a *Adapter) messageEvents(m *amqp.Message) ([]sinkEvent, error) {
+	// If the message already carries a CloudEvent, forward it unchanged.
+	// Otherwise create a new CloudEvents event from an arbitrary AMQP message.
+	if isStructured((*m).ContentType()) {
+		events, err := structuredEvents(*m)
+		if err != nil {
+			a.logger().Warnw("Failed to decode structured CloudEvent", zap.Error(err))
+			return nil, err
+		}
+		size := 0
+		for _, e := range events {
+			size += len(e.data)
+		}
+		a.metrics.messageBody(size)
+		return events, nil
+	}
+	attrs := ceAttributes(*m)
 
-	var rdr *amqpBodyReader
+	// String and binary bodies are forwarded byte for byte with the
+	// original content type.
+	var data []byte
 	ctype := (*m).ContentType()
 	var body = (*m).Body()
-	log.Printf("body switch time with : %T ... %v", body, body)
 	switch body.(type) {
 	case string:
-		log.Printf("body switch string")
-		ctype = "text/plain; charset=utf-8"
-		rdr = newAmqpBodyReader(body.(string))
+		if ctype == "" {
+			ctype = "text/plain; charset=utf-8"
+		}
+		data = []byte(body.(string))
 	case amqp.Binary:
-		log.Printf("body switch bin")
 		if ctype == "" {
 			ctype = "application/octet-stream"
-			rdr = newAmqpBodyReader(body.(amqp.Binary).String())
 		}
+		data = []byte(body.(amqp.Binary))
 	default:
-		return fmt.Errorf("AMQP message format not supported")
+		// AmqpSequence, or any other AmqpValue: map, list, number, described type...
+		j, err := valueJSON(body)
+		if err != nil {
+			return nil, fmt.Errorf("AMQP message format not supported: %s", err)
+		}
+		ctype = "application/json"
+		data = j
 	}
 
-	ctx := cloudevents.EventContext{
-		CloudEventsVersion: cloudevents.CloudEventsVersion,
-		EventType:          "amqp.message.delivery",
-		EventID:            messageIdString(m),
-		EventTime:          (*m).CreationTime(),
-		Source:             a.SpecSource,
-		ContentType:        ctype,
-	}
+	var ctx cloudevents.EventContext
 	var err error
-	var req *http.Request
-	if rdr != nil {
-		req, err = cloudevents.Binary.NewRequest(a.SinkURI, rdr, ctx)
+	if attrs != nil {
+		if ctx, err = binaryEventContext(*m, attrs); err != nil {
+			return nil, err
+		}
+		ctx.ContentType = ctype
+		for k, v := range a.messageExtensions(*m) {
+			if _, ok := ctx.Extensions[k]; ok {
+				continue
+			}
+			if ctx.Extensions == nil {
+				ctx.Extensions = make(map[string]interface{})
+			}
+			ctx.Extensions[k] = v
+		}
 	} else {
-		// Binary data section in json.
-		// For now, lib just calls json.Marshall(), so pass as string, not reader
-		req, err = cloudevents.Binary.NewRequest(a.SinkURI, body.(amqp.Binary).String(), ctx)
+		ctx = cloudevents.EventContext{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventType:          "amqp.message.delivery",
+			EventID:            messageIdString(m),
+			EventTime:          (*m).CreationTime(),
+			Source:             a.SpecSource,
+			ContentType:        ctype,
+			Extensions:         a.messageExtensions(*m),
+		}
+	}
+	a.metrics.messageBody(len(data))
+	return []sinkEvent{{ctx: ctx, data: data}}, nil
+}
+
+// newRequest creates the HTTP request for an event in the adapter's
+// content mode and spec version.
+func (a *Adapter) newRequest(ctx cloudevents.EventContext, data []byte) (*http.Request, error) {
+	if a.ContentMode == Structured {
+		return newStructuredRequest(a.SinkURI, a.SpecVersion, ctx, data)
 	}
+	return newBinaryRequest(a.SinkURI, a.SpecVersion, ctx, data)
+}
+
+// send makes a single attempt to send a request to the sink.  newRequest
+// is called for each attempt, since a request body can only be read once,
+// with the context of the attempt's span.
+func (a *Adapter) send(parent context.Context, newRequest func(context.Context) (*http.Request, error), timeout time.Duration) (result error) {
+	logger := a.logger()
+
+	reqctx, cancel := context.WithTimeout(parent, timeout)
+	defer cancel()
+	reqctx, span := a.startSinkRequest(reqctx)
+	defer func() { endSpan(span, result) }()
+	req, err := newRequest(reqctx)
 	if err != nil {
-		log.Printf("Failed to marshal the message: %+v : %s", m, err)
+		logger.Errorw("Failed to marshal the event", zap.Error(err))
+		// Not sent, and resending would fail the same way.
 		return err
 	}
+	if err := checkHeaders(req.Header); err != nil {
+		logger.Errorw("Event cannot be sent in HTTP headers", zap.Error(err))
+		return err
+	}
+	injectTraceContext(reqctx, span, req)
+	req = req.WithContext(reqctx)
 
-	logger.Debug("posting to SinkURI", zap.Any("SinkURI", a.SinkURI))
-	client := &http.Client{}
+	logger.Debugw("Posting to sink", "sink", RedactURL(a.SinkURI))
+	client, err := a.sinkClient()
+	if err != nil {
+		return &sinkError{err: err}
+	}
+	start := time.Now()
 	resp, err := client.Do(req)
 	if err != nil {
-		logger.Error("failed to do POST", zap.Error(err))
-		return err
+		a.metrics.sinkRequest(0, time.Since(start))
+		logger.Warnw("Failed to post to sink", zap.Error(err))
+		return &sinkError{err: err}
 	}
+	a.metrics.sinkRequest(resp.StatusCode, time.Since(start))
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 	defer resp.Body.Close()
-	respbody, _ := ioutil.ReadAll(resp.Body)
-	logger.Debug("response", zap.Any("status", resp.Status), zap.Any("body", string(respbody)))
+	// Drain the body, which is not logged, so the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+	logger.Debugw("Sink responded", "status", resp.Status)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &sinkError{
+			status: resp.StatusCode,
+			err:    fmt.Errorf("sink responded %s", resp.Status),
+		}
+	}
 	return nil
 }
 
-func (a *Adapter) dial(u *url.URL) (conn net.Conn, err error) {
+// dial opens a connection to the source's broker at u, with the TLS
+// settings of connect-config.
+func (a *Adapter) dial(u *url.URL) (net.Conn, error) {
+	var settings *TLSConfig
+	if a.config != nil {
+		settings = a.config.TLS
+	}
+	return a.dialTLS(u, settings)
+}
+
+func (a *Adapter) dialTLS(u *url.URL, settings *TLSConfig) (conn net.Conn, err error) {
 	if u.Scheme == "amqp" {
 		return net.Dial("tcp", u.Host)
 	}
@@ -192,16 +401,19 @@ func (a *Adapter) dial(u *url.URL) (conn net.Conn, err error) {
 		}
 	}
 
-	return tls.Dial("tcp", u.Host, &tls.Config{
+	config := &tls.Config{
 		RootCAs: roots,
 		InsecureSkipVerify: false,
-	})
-}
-
-func fatalIf(err error) {
-	if err != nil {
-		log.Fatal(err)
 	}
+	if a.ClientCert != nil {
+		config.Certificates = []tls.Certificate{*a.ClientCert}
+	}
+	if settings != nil {
+		if err = settings.apply(config); err != nil {
+			return
+		}
+	}
+	return tls.Dial("tcp", u.Host, config)
 }
 
 type ConnectConfig struct {
@@ -210,20 +422,41 @@ type ConnectConfig struct {
 	Port      string `json:"port"`
 	User      string `json:"user"`
 	Password  string `json:"password"`
-	// TODO: SASL and TLS sub-structs
+	// Further brokers, as "host:port", tried in order after Host and Port
+	// when the connection fails or is lost.
+	Failover []string `json:"failover"`
+	// Randomize the order in which the brokers are tried.
+	Randomize bool `json:"randomize"`
+	// Backoff between rounds of connection attempts.
+	Reconnect *ReconnectConfig `json:"reconnect"`
+	// Authentication settings.
+	SASL *SASLConfig `json:"sasl"`
+	// TLS settings for amqps connections.
+	TLS *TLSConfig `json:"tls"`
 }
 
-func parseConfigBytes(bytes []byte) *ConnectConfig {
+func parseConfigBytes(bytes []byte) (*ConnectConfig, error) {
 	var config ConnectConfig
-	err := json.Unmarshal(bytes, &config)
-	fatalIf(err);
-	return &config
+	if err := json.Unmarshal(bytes, &config); err != nil {
+		return nil, fmt.Errorf("bad connect-config: %s", err)
+	}
+	if config.SASL != nil {
+		if err := config.SASL.validate(); err != nil {
+			return nil, fmt.Errorf("bad connect-config: %s", err)
+		}
+	}
+	if config.TLS != nil {
+		if err := config.TLS.validate(); err != nil {
+			return nil, fmt.Errorf("bad connect-config: %s", err)
+		}
+	}
+	return &config, nil
 }
 
-func (a *Adapter) applyConfig(u *url.URL) {
+func (a *Adapter) applyConfig(u *url.URL) error {
 	// values already in url take precedence over connect-config data
 	if a.CredsPath == "" {
-		return
+		return nil
 	}
 	cdir := a.CredsPath
 	if !strings.HasSuffix(cdir, "/") {
@@ -232,28 +465,52 @@ func (a *Adapter) applyConfig(u *url.URL) {
 	var b []byte
 	var err error
 	if b, err = ioutil.ReadFile(cdir + "connect-config"); err == nil {
-		if config := parseConfigBytes(b); config != nil {
-			if u.Scheme == "" {
-				u.Scheme = config.Scheme
-			}
-			if u.Host == "" {
-				u.Host = net.JoinHostPort(config.Host, config.Port)
-			}
-			if u.User == nil {
-				u.User = url.UserPassword(config.User, config.Password)
-			}
+		config, err := parseConfigBytes(b)
+		if err != nil {
+			return err
+		}
+		a.config = config
+		config.fillURL(u)
+		if config.TLS != nil && config.TLS.Verify == VerifyNone {
+			a.logger().Warn("TLS verify is none: the broker's certificate is not verified")
 		}
 	}
 	if b, err = ioutil.ReadFile(cdir + "tls.ca"); err == nil {
 		a.RootCA = b
 	}
+	cert, err := loadClientCert(cdir)
+	if err != nil {
+		return fmt.Errorf("bad config secret: %s", err)
+	}
+	if cert != nil {
+		a.ClientCert = cert
+	}
+	return nil
+}
+
+// fillURL sets the scheme, host and credentials of u, where it has none,
+// from c.
+func (c *ConnectConfig) fillURL(u *url.URL) {
+	if u.Scheme == "" {
+		u.Scheme = c.Scheme
+	}
+	if u.Host == "" {
+		u.Host = net.JoinHostPort(c.Host, c.Port)
+	}
+	if u.User == nil {
+		u.User = url.UserPassword(c.User, c.Password)
+	}
 }
 
 func messageIdString(m *amqp.Message) string {
 	if m == nil {
 		return ""
 	}
-	msgid := (*m).MessageId()
+	return idString((*m).MessageId())
+}
+
+// idString converts a message-id or correlation-id to a string.
+func idString(msgid interface{}) string {
 	// AMQP specifies four legal Message ID data types, mapped to the following Go types by Proton.
 	// CloudEvents requires the Message ID as string type only.
 	switch msgid.(type) {
@@ -262,34 +519,10 @@ func messageIdString(m *amqp.Message) string {
 	case uint64:
 		return fmt.Sprintf("%d", msgid)
 	case amqp.UUID:
-		s := msgid.(amqp.UUID).String()
-		// s formatted as "UUID(c4b04c04-8a8e-4a7d-948a-5e5843433b4d)" , strip enclosing "UUID()" notation
-		return s[5:len(s)-1]
+		return uuidString(msgid.(amqp.UUID))
 	case amqp.Binary:
 		return base64.StdEncoding.EncodeToString([]byte(msgid.(amqp.Binary)))
 	default:
 		return ""
 	}
-
-}
-
-func newAmqpBodyReader (b string) *amqpBodyReader {
-	log.Printf("body reader NEW with : %v", b)
-	return &amqpBodyReader{
-		body:    b,
-		offset:  0,
-	}
-}
-
-func (rdr *amqpBodyReader) Read(out []byte) (n int, err error) {
-	if rdr.offset < len(rdr.body) {
-		bytes := rdr.body[rdr.offset:]
-		n = copy(out, bytes)
-		rdr.offset += n
-	}
-	if rdr.offset >= len(rdr.body) {
-		err = io.EOF
-	}
-	log.Printf("body reader READ with : %d and %v", n, err)
-	return
 }
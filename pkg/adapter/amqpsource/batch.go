@@ -0,0 +1,154 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"qpid.apache.org/amqp"
+	"qpid.apache.org/electron"
+)
+
+const defaultBatchWindow = 100 * time.Millisecond
+
+// batchWindow returns the longest time a message waits for its batch to
+// fill up.
+func (a *Adapter) batchWindow() time.Duration {
+	if a.BatchWindow <= 0 {
+		return defaultBatchWindow
+	}
+	return a.BatchWindow
+}
+
+// addToBatch adds rm to the pending batch, which is delivered once it holds
+// BatchSize messages or, from the receive loop, when deadline fires.
+func (d *dispatcher) addToBatch(rm electron.ReceivedMessage) {
+	d.batch = append(d.batch, rm)
+	if len(d.batch) == 1 {
+		d.window = time.NewTimer(d.a.batchWindow())
+	}
+	if len(d.batch) >= d.a.BatchSize {
+		d.flush()
+	}
+}
+
+// deadline returns a channel that receives when the pending batch is due,
+// or nil if there is no pending batch.
+func (d *dispatcher) deadline() <-chan time.Time {
+	if d.window == nil {
+		return nil
+	}
+	return d.window.C
+}
+
+// flush delivers the pending batch and settles its messages.  Batches are
+// delivered one at a time, in the order received.
+func (d *dispatcher) flush() {
+	batch := d.takeBatch()
+	if len(batch) > 0 {
+		d.a.deliverBatch(batch)
+	}
+}
+
+// releaseBatch releases the messages of the pending batch when delivery on
+// the link stops, so the broker can redeliver them at once.
+func (d *dispatcher) releaseBatch() {
+	batch := d.takeBatch()
+	for i := range batch {
+		d.a.settleAs(&batch[i], Release)
+	}
+}
+
+func (d *dispatcher) takeBatch() []electron.ReceivedMessage {
+	if d.window != nil {
+		d.window.Stop()
+		d.window = nil
+	}
+	batch := d.batch
+	d.batch = nil
+	return batch
+}
+
+// deliverBatch posts the events of rms to the sink as a single batch and
+// settles every message according to the response.  Messages that cannot
+// be converted are settled on their own and left out of the batch.
+func (a *Adapter) deliverBatch(rms []electron.ReceivedMessage) {
+	var events []sinkEvent
+	var batched []*electron.ReceivedMessage
+	for i := range rms {
+		rm := &rms[i]
+		e, err := a.messageEvents(&rm.Message)
+		if err != nil {
+			a.settle(rm, err)
+			continue
+		}
+		events = append(events, e...)
+		batched = append(batched, rm)
+	}
+
+	var err error
+	if len(events) > 0 {
+		ms := make([]amqp.Message, len(batched))
+		for i, rm := range batched {
+			ms[i] = rm.Message
+		}
+		parent, span := a.startReceiveBatch(ms)
+		a.logger().Debugw("Posting batch", "events", len(events), "messages", len(batched))
+		err = a.postBatch(parent, events)
+		defer endSpan(span, err)
+	}
+	for _, rm := range batched {
+		a.settle(rm, err)
+	}
+}
+
+// postBatch sends events to the sink in a single request, retrying
+// transient failures like postEvent.
+func (a *Adapter) postBatch(parent context.Context, events []sinkEvent) error {
+	what := fmt.Sprintf("batch of %d events", len(events))
+	return a.post(parent, what, func(reqctx context.Context) (*http.Request, error) {
+		traced := make([]sinkEvent, len(events))
+		for i, e := range events {
+			traced[i] = sinkEvent{ctx: eventTraceContext(reqctx, e.ctx), data: e.data}
+		}
+		return newBatchRequest(a.SinkURI, a.SpecVersion, traced)
+	})
+}
+
+// newBatchRequest creates a batched content mode HTTP request: a JSON
+// array of the events' structured mode envelopes.
+func newBatchRequest(sinkURI string, version SpecVersion, events []sinkEvent) (*http.Request, error) {
+	envelopes := make([]map[string]interface{}, len(events))
+	for i, e := range events {
+		envelopes[i] = eventEnvelope(version, e.ctx, e.data)
+	}
+	b, err := json.Marshal(envelopes)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, sinkURI, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ceBatchType+"; charset=utf-8")
+	return req, nil
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/pkg/cloudevents"
+	"qpid.apache.org/amqp"
+)
+
+func TestPostBatch(t *testing.T) {
+	var gotType string
+	var got interface{}
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &got); err != nil {
+			t.Errorf("invalid batch %s: %v", body, err)
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	events := []sinkEvent{{
+		ctx: cloudevents.EventContext{
+			EventID:     "1",
+			EventType:   "t",
+			Source:      "s",
+			ContentType: "application/json",
+		},
+		data: []byte(`{"a":1}`),
+	}, {
+		ctx: cloudevents.EventContext{
+			EventID:     "2",
+			EventType:   "t",
+			Source:      "s",
+			ContentType: "text/plain",
+		},
+		data: []byte("hello"),
+	}}
+	a := &Adapter{SinkURI: sink.URL}
+	if err := a.postBatch(context.Background(), events); err != nil {
+		t.Fatalf("postBatch() error = %v", err)
+	}
+
+	if want := "application/cloudevents-batch+json; charset=utf-8"; gotType != want {
+		t.Errorf("Content-Type = %q, want %q", gotType, want)
+	}
+	var want interface{}
+	json.Unmarshal([]byte(`[
+		{"specversion":"1.0","id":"1","type":"t","source":"s","datacontenttype":"application/json","data":{"a":1}},
+		{"specversion":"1.0","id":"2","type":"t","source":"s","datacontenttype":"text/plain","data":"hello"}
+	]`), &want)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected batch (-want, +got) = %v", diff)
+	}
+}
+
+func TestPostMessageBatch(t *testing.T) {
+	var requests []int
+	statuses := []int{http.StatusServiceUnavailable, http.StatusAccepted}
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/cloudevents-batch+json; charset=utf-8" {
+			t.Errorf("Content-Type = %q, want a batch", ct)
+		}
+		var batch []interface{}
+		body, _ := ioutil.ReadAll(r.Body)
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Errorf("invalid batch %s: %v", body, err)
+		}
+		requests = append(requests, len(batch))
+		w.WriteHeader(statuses[len(requests)-1])
+	}))
+	defer sink.Close()
+
+	m := amqp.NewMessage()
+	m.SetContentType("application/cloudevents-batch+json")
+	m.Marshal(amqp.Binary(`[
+		{"specversion":"1.0","id":"1","type":"t","source":"s","data":{"a":1}},
+		{"specversion":"1.0","id":"2","type":"t","source":"s","data":{"a":2}}
+	]`))
+	a := &Adapter{SinkURI: sink.URL, Retry: RetryPolicy{InitialBackoff: time.Millisecond}}
+	if err := a.postMessage(context.Background(), &m); err != nil {
+		t.Fatalf("postMessage() error = %v", err)
+	}
+	// Both events are sent, and retried, in a single request.
+	if diff := cmp.Diff([]int{2, 2}, requests); diff != "" {
+		t.Errorf("unexpected requests (-want, +got) = %v", diff)
+	}
+}
@@ -0,0 +1,309 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/knative/pkg/cloudevents"
+	"qpid.apache.org/amqp"
+)
+
+// Decoding of AMQP messages that already carry a CloudEvent, as described by
+// the CloudEvents AMQP protocol binding:
+// https://github.com/cloudevents/spec/blob/master/amqp-transport-binding.md
+
+const (
+	// In binary content mode each event attribute is an application
+	// property with this prefix.  Earlier drafts of the binding used
+	// ceLegacyPropPrefix, which is still accepted on input.
+	cePropPrefix       = "cloudEvents_"
+	ceLegacyPropPrefix = "cloudEvents:"
+)
+
+// ceAttributes returns the CloudEvents attributes carried in the
+// application properties of m, keyed by lower case attribute name.  It
+// returns nil unless m is a binary content mode event, i.e. at least the
+// specversion attribute is present.
+func ceAttributes(m amqp.Message) map[string]interface{} {
+	var attrs map[string]interface{}
+	for k, v := range m.ApplicationProperties() {
+		var name string
+		switch {
+		case strings.HasPrefix(k, cePropPrefix):
+			name = k[len(cePropPrefix):]
+		case strings.HasPrefix(k, ceLegacyPropPrefix):
+			name = k[len(ceLegacyPropPrefix):]
+		default:
+			continue
+		}
+		if name == "" {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]interface{})
+		}
+		attrs[strings.ToLower(name)] = v
+	}
+	if _, ok := attrs["specversion"]; !ok {
+		return nil
+	}
+	return attrs
+}
+
+// binaryEventContext builds the context of a binary content mode event
+// from its decoded attributes.  The id, type, source and time are forwarded
+// unchanged; extension names and values are converted like application
+// properties.  The datacontenttype attribute is
+// carried by the AMQP content-type property, not an application property.
+func binaryEventContext(m amqp.Message, attrs map[string]interface{}) (cloudevents.EventContext, error) {
+	ctx := cloudevents.EventContext{
+		CloudEventsVersion: cloudevents.CloudEventsVersion,
+		ContentType:        m.ContentType(),
+	}
+	for _, required := range []string{"id", "type", "source"} {
+		s, ok := attrs[required].(string)
+		if !ok || s == "" {
+			return ctx, fmt.Errorf("binary mode CloudEvent: missing or invalid %q attribute", required)
+		}
+	}
+	ctx.EventID = attrs["id"].(string)
+	ctx.EventType = attrs["type"].(string)
+	ctx.Source = attrs["source"].(string)
+
+	for name, v := range attrs {
+		switch name {
+		case "specversion", "id", "type", "source":
+			// Handled above.
+		case "time":
+			t, err := ceTime(v)
+			if err != nil {
+				return ctx, err
+			}
+			ctx.EventTime = t
+		case "dataschema", "schemaurl":
+			ctx.SchemaURL = fmt.Sprint(v)
+		case "datacontenttype", "contenttype":
+			// The AMQP content-type property takes precedence.
+			if ctx.ContentType == "" {
+				ctx.ContentType = fmt.Sprint(v)
+			}
+		default:
+			// Includes "subject", which has no 0.1 context attribute.
+			// Extensions follow the same naming and type rules as
+			// application properties mapped to extensions.
+			ext := ceExtensionName(name)
+			if ext == "" {
+				continue
+			}
+			ev, ok := ceExtensionValue(v)
+			if !ok {
+				continue
+			}
+			if ctx.Extensions == nil {
+				ctx.Extensions = make(map[string]interface{})
+			}
+			ctx.Extensions[ext] = ev
+		}
+	}
+	return ctx, nil
+}
+
+// ceTime converts the time attribute, which the binding allows as either an
+// AMQP timestamp or an RFC 3339 string.
+func ceTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case string:
+		parsed, err := time.Parse(time.RFC3339Nano, t)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("CloudEvent: invalid time %q: %s", t, err)
+		}
+		return parsed, nil
+	default:
+		return time.Time{}, fmt.Errorf("CloudEvent: invalid time type %T", v)
+	}
+}
+
+const (
+	// Media types of structured content mode messages.
+	ceStructuredType = "application/cloudevents+json"
+	ceBatchType      = "application/cloudevents-batch+json"
+)
+
+// sinkEvent is an event converted from an AMQP message, ready to be
+// posted to the sink.
+type sinkEvent struct {
+	ctx  cloudevents.EventContext
+	data []byte
+}
+
+// isStructured returns true if contentType identifies a structured or
+// batched content mode CloudEvent.
+func isStructured(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mt == ceStructuredType || mt == ceBatchType)
+}
+
+// structuredEvents decodes the JSON envelope(s) in the body of a
+// structured content mode message.  A batch yields one event per element.
+func structuredEvents(m amqp.Message) ([]sinkEvent, error) {
+	var b []byte
+	switch body := m.Body().(type) {
+	case amqp.Binary:
+		b = []byte(body)
+	case string:
+		b = []byte(body)
+	default:
+		return nil, fmt.Errorf("structured mode CloudEvent: unsupported body type %T", body)
+	}
+
+	var envelopes []map[string]json.RawMessage
+	mt, _, _ := mime.ParseMediaType(m.ContentType())
+	if mt == ceBatchType {
+		if err := json.Unmarshal(b, &envelopes); err != nil {
+			return nil, fmt.Errorf("structured mode CloudEvent: invalid batch: %s", err)
+		}
+	} else {
+		var envelope map[string]json.RawMessage
+		if err := json.Unmarshal(b, &envelope); err != nil {
+			return nil, fmt.Errorf("structured mode CloudEvent: invalid envelope: %s", err)
+		}
+		envelopes = append(envelopes, envelope)
+	}
+
+	events := make([]sinkEvent, 0, len(envelopes))
+	for _, envelope := range envelopes {
+		e, err := decodeEnvelope(envelope)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
+
+// decodeEnvelope converts a JSON event envelope.  Both the 0.1 attribute
+// names (eventID, eventType, ...) and the later ones (id, type, ...) are
+// accepted.  Unknown members are treated as extensions.
+func decodeEnvelope(envelope map[string]json.RawMessage) (sinkEvent, error) {
+	e := sinkEvent{
+		ctx: cloudevents.EventContext{CloudEventsVersion: cloudevents.CloudEventsVersion},
+	}
+	str := func(raw json.RawMessage) (string, error) {
+		var s string
+		err := json.Unmarshal(raw, &s)
+		return s, err
+	}
+	var data, dataBase64 json.RawMessage
+	for name, raw := range envelope {
+		var err error
+		switch name {
+		case "specversion", "cloudEventsVersion":
+			// Output uses the adapter's SpecVersion.
+		case "id", "eventID":
+			e.ctx.EventID, err = str(raw)
+		case "type", "eventType":
+			e.ctx.EventType, err = str(raw)
+		case "eventTypeVersion":
+			e.ctx.EventTypeVersion, err = str(raw)
+		case "source":
+			e.ctx.Source, err = str(raw)
+		case "time", "eventTime":
+			var s string
+			if s, err = str(raw); err == nil {
+				e.ctx.EventTime, err = ceTime(s)
+			}
+		case "datacontenttype", "contentType":
+			e.ctx.ContentType, err = str(raw)
+		case "dataschema", "schemaurl", "schemaURL":
+			e.ctx.SchemaURL, err = str(raw)
+		case "data":
+			data = raw
+		case "data_base64":
+			dataBase64 = raw
+		case "extensions":
+			var ext map[string]interface{}
+			if err = json.Unmarshal(raw, &ext); err == nil {
+				for k, v := range ext {
+					e.setExtension(k, v)
+				}
+			}
+		default:
+			var v interface{}
+			if err = json.Unmarshal(raw, &v); err == nil {
+				e.setExtension(name, v)
+			}
+		}
+		if err != nil {
+			return e, fmt.Errorf("structured mode CloudEvent: invalid %q attribute: %s", name, err)
+		}
+	}
+	if e.ctx.EventID == "" || e.ctx.EventType == "" || e.ctx.Source == "" {
+		return e, fmt.Errorf("structured mode CloudEvent: missing id, type or source")
+	}
+
+	switch {
+	case dataBase64 != nil:
+		s, err := str(dataBase64)
+		if err == nil {
+			var b []byte
+			if b, err = base64.StdEncoding.DecodeString(s); err == nil {
+				e.data = b
+			}
+		}
+		if err != nil {
+			return e, fmt.Errorf("structured mode CloudEvent: invalid data_base64: %s", err)
+		}
+	case data != nil:
+		if e.ctx.ContentType == "" {
+			e.ctx.ContentType = "application/json"
+		}
+		if s, err := str(data); err == nil && !isJSON(e.ctx.ContentType) {
+			// Non-JSON data, e.g. text or XML, is carried as a JSON string.
+			e.data = []byte(s)
+		} else {
+			e.data = data
+		}
+	}
+	return e, nil
+}
+
+func (e *sinkEvent) setExtension(name string, v interface{}) {
+	// Envelope members may have any name; only valid ones can become
+	// headers in binary mode.
+	if name = ceExtensionName(name); name == "" {
+		return
+	}
+	if e.ctx.Extensions == nil {
+		e.ctx.Extensions = make(map[string]interface{})
+	}
+	e.ctx.Extensions[name] = v
+}
+
+// isJSON returns true if contentType is application/json or a +json
+// structured syntax suffix type.
+func isJSON(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	return err == nil && (mt == "application/json" || strings.HasSuffix(mt, "+json"))
+}
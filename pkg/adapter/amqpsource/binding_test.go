@@ -0,0 +1,262 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/pkg/cloudevents"
+	"qpid.apache.org/amqp"
+)
+
+func TestBinaryEventContext(t *testing.T) {
+	eventTime := time.Date(2018, 11, 20, 13, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		props   map[string]interface{}
+		ctype   string
+		want    *cloudevents.EventContext
+		wantErr bool
+	}{{
+		name:  "not an event",
+		props: map[string]interface{}{"color": "red"},
+		want:  nil,
+	}, {
+		name: "required attributes",
+		props: map[string]interface{}{
+			"cloudEvents_specversion": "1.0",
+			"cloudEvents_id":          "abc-123",
+			"cloudEvents_type":        "com.example.order",
+			"cloudEvents_source":      "/orders",
+		},
+		ctype: "application/json",
+		want: &cloudevents.EventContext{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "abc-123",
+			EventType:          "com.example.order",
+			Source:             "/orders",
+			ContentType:        "application/json",
+		},
+	}, {
+		name: "legacy prefix with time, subject and extension",
+		props: map[string]interface{}{
+			"cloudEvents:specversion": "0.3",
+			"cloudEvents:id":          "1",
+			"cloudEvents:type":        "t",
+			"cloudEvents:source":      "s",
+			"cloudEvents:time":        eventTime,
+			"cloudEvents:subject":     "order-7",
+			"cloudEvents:region":      "emea",
+		},
+		want: &cloudevents.EventContext{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "1",
+			EventType:          "t",
+			Source:             "s",
+			EventTime:          eventTime,
+			Extensions: map[string]interface{}{
+				"subject": "order-7",
+				"region":  "emea",
+			},
+		},
+	}, {
+		name: "string time",
+		props: map[string]interface{}{
+			"cloudEvents_specversion": "1.0",
+			"cloudEvents_id":          "1",
+			"cloudEvents_type":        "t",
+			"cloudEvents_source":      "s",
+			"cloudEvents_time":        "2018-11-20T13:00:00Z",
+		},
+		want: &cloudevents.EventContext{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "1",
+			EventType:          "t",
+			Source:             "s",
+			EventTime:          eventTime,
+		},
+	}, {
+		name: "timestamp, uuid and invalid name extensions",
+		props: map[string]interface{}{
+			"cloudEvents_specversion": "1.0",
+			"cloudEvents_id":          "1",
+			"cloudEvents_type":        "t",
+			"cloudEvents_source":      "s",
+			"cloudEvents_shipped":     eventTime,
+			"cloudEvents_orderid": amqp.UUID{0xc4, 0xb0, 0x4c, 0x04, 0x8a, 0x8e, 0x4a, 0x7d,
+				0x94, 0x8a, 0x5e, 0x58, 0x43, 0x43, 0x3b, 0x4d},
+			"cloudEvents_my-ext": "value",
+			"cloudEvents_-":      "dropped",
+		},
+		want: &cloudevents.EventContext{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "1",
+			EventType:          "t",
+			Source:             "s",
+			Extensions: map[string]interface{}{
+				"shipped": "2018-11-20T13:00:00Z",
+				"orderid": "c4b04c04-8a8e-4a7d-948a-5e5843433b4d",
+				"myext":   "value",
+			},
+		},
+	}, {
+		name: "missing type",
+		props: map[string]interface{}{
+			"cloudEvents_specversion": "1.0",
+			"cloudEvents_id":          "1",
+			"cloudEvents_source":      "s",
+		},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := amqp.NewMessage()
+			m.SetApplicationProperties(test.props)
+			m.SetContentType(test.ctype)
+			attrs := ceAttributes(m)
+			if attrs == nil {
+				if test.want != nil || test.wantErr {
+					t.Fatalf("ceAttributes() = nil, want event attributes")
+				}
+				return
+			}
+			got, err := binaryEventContext(m, attrs)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("binaryEventContext() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("binaryEventContext() error = %v", err)
+			}
+			if diff := cmp.Diff(*test.want, got); diff != "" {
+				t.Errorf("unexpected context (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestStructuredEvents(t *testing.T) {
+	tests := []struct {
+		name     string
+		ctype    string
+		body     string
+		want     []cloudevents.EventContext
+		wantData []string
+		wantErr  bool
+	}{{
+		name:  "single event",
+		ctype: "application/cloudevents+json; charset=utf-8",
+		body:  `{"specversion":"1.0","id":"1","type":"t","source":"s","region":"emea","data":{"a":1}}`,
+		want: []cloudevents.EventContext{{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "1",
+			EventType:          "t",
+			Source:             "s",
+			ContentType:        "application/json",
+			Extensions:         map[string]interface{}{"region": "emea"},
+		}},
+		wantData: []string{`{"a":1}`},
+	}, {
+		name:  "0.1 attribute names",
+		ctype: "application/cloudevents+json",
+		body:  `{"cloudEventsVersion":"0.1","eventID":"1","eventType":"t","source":"s","extensions":{"x":"y"}}`,
+		want: []cloudevents.EventContext{{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "1",
+			EventType:          "t",
+			Source:             "s",
+			Extensions:         map[string]interface{}{"x": "y"},
+		}},
+		wantData: []string{""},
+	}, {
+		name:  "extension names sanitized",
+		ctype: "application/cloudevents+json",
+		body:  `{"specversion":"1.0","id":"1","type":"t","source":"s","Trace-ID":"abc","_":1,"extensions":{"x.y":"z","Bad Name\n":true}}`,
+		want: []cloudevents.EventContext{{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "1",
+			EventType:          "t",
+			Source:             "s",
+			Extensions:         map[string]interface{}{"traceid": "abc", "xy": "z", "badname": true},
+		}},
+		wantData: []string{""},
+	}, {
+		name:  "batch",
+		ctype: "application/cloudevents-batch+json",
+		body:  `[{"id":"1","type":"t","source":"s"},{"id":"2","type":"t","source":"s"}]`,
+		want: []cloudevents.EventContext{{
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "1",
+			EventType:          "t",
+			Source:             "s",
+		}, {
+			CloudEventsVersion: cloudevents.CloudEventsVersion,
+			EventID:            "2",
+			EventType:          "t",
+			Source:             "s",
+		}},
+		wantData: []string{"", ""},
+	}, {
+		name:    "missing id",
+		ctype:   "application/cloudevents+json",
+		body:    `{"type":"t","source":"s"}`,
+		wantErr: true,
+	}, {
+		name:    "not json",
+		ctype:   "application/cloudevents+json",
+		body:    `<event/>`,
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !isStructured(test.ctype) {
+				t.Fatalf("isStructured(%q) = false", test.ctype)
+			}
+			m := amqp.NewMessage()
+			m.SetContentType(test.ctype)
+			m.Marshal(amqp.Binary(test.body))
+			events, err := structuredEvents(m)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("structuredEvents() = %+v, want error", events)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("structuredEvents() error = %v", err)
+			}
+			var got []cloudevents.EventContext
+			var gotData []string
+			for _, e := range events {
+				got = append(got, e.ctx)
+				gotData = append(gotData, string(e.data))
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected contexts (-want, +got) = %v", diff)
+			}
+			if diff := cmp.Diff(test.wantData, gotData); diff != "" {
+				t.Errorf("unexpected data (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+
+	"qpid.apache.org/amqp"
+)
+
+// valueJSON encodes an AmqpValue body as JSON.  The encoding is
+// deterministic: object members are sorted by key.  AMQP types map to JSON
+// as follows:
+//
+//	null                      null
+//	boolean                   true/false
+//	integer types             number
+//	float, double             number, or "NaN", "+Inf", "-Inf" strings
+//	string, symbol, char      string
+//	timestamp                 RFC 3339 string, UTC
+//	uuid                      string, 8-4-4-4-12 form
+//	binary                    base64 string
+//	list, array               array
+//	map                       object; non-string keys use their JSON text
+//	described                 {"descriptor": ..., "value": ...}
+func valueJSON(v interface{}) (json.RawMessage, error) {
+	j, err := amqpJSON(v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(j)
+}
+
+// amqpJSON converts an AMQP value to one encoding/json marshals as
+// described for valueJSON.
+func amqpJSON(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case nil, bool, string,
+		int8, int16, int32, int64, int,
+		uint8, uint16, uint32, uint64, uint:
+		return t, nil
+	case float32:
+		return jsonFloat(float64(t)), nil
+	case float64:
+		return jsonFloat(t), nil
+	case amqp.Symbol:
+		return string(t), nil
+	case amqp.Char:
+		return string(rune(t)), nil
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano), nil
+	case amqp.UUID:
+		return uuidString(t), nil
+	case amqp.Binary:
+		return base64.StdEncoding.EncodeToString([]byte(t)), nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(t), nil
+	case amqp.Described:
+		d, err := amqpJSON(t.Descriptor)
+		if err != nil {
+			return nil, err
+		}
+		val, err := amqpJSON(t.Value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"descriptor": d, "value": val}, nil
+	case amqp.List:
+		return jsonArray(reflect.ValueOf([]interface{}(t)))
+	case amqp.Map:
+		return jsonObject(reflect.ValueOf(map[interface{}]interface{}(t)))
+	}
+
+	// Typed slices and maps, e.g. from AMQP arrays.
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		return jsonArray(rv)
+	case reflect.Map:
+		return jsonObject(rv)
+	}
+	return nil, fmt.Errorf("AMQP type %T not supported", v)
+}
+
+func jsonFloat(f float64) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return f
+}
+
+func jsonArray(rv reflect.Value) (interface{}, error) {
+	a := make([]interface{}, rv.Len())
+	for i := range a {
+		var err error
+		if a[i], err = amqpJSON(rv.Index(i).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// jsonObject converts a map.  encoding/json sorts the resulting keys.  Keys
+// that convert to the same string, e.g. the string "1" and the integer 1,
+// are an error since either choice would lose data.
+func jsonObject(rv reflect.Value) (interface{}, error) {
+	o := make(map[string]interface{}, rv.Len())
+	for _, k := range rv.MapKeys() {
+		key, err := jsonKey(k.Interface())
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := o[key]; dup {
+			return nil, fmt.Errorf("AMQP map has duplicate key %q after conversion to JSON", key)
+		}
+		if o[key], err = amqpJSON(rv.MapIndex(k).Interface()); err != nil {
+			return nil, err
+		}
+	}
+	return o, nil
+}
+
+func jsonKey(k interface{}) (string, error) {
+	j, err := amqpJSON(k)
+	if err != nil {
+		return "", err
+	}
+	if s, ok := j.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(j)
+	return string(b), err
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"qpid.apache.org/amqp"
+)
+
+func TestValueJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{{
+		name:  "null",
+		value: nil,
+		want:  `null`,
+	}, {
+		name:  "numbers",
+		value: amqp.List{int8(-1), uint64(math.MaxUint64), 1.5, float32(2), math.Inf(-1)},
+		want:  `[-1,18446744073709551615,1.5,2,"-Inf"]`,
+	}, {
+		name: "map with sorted and non-string keys",
+		value: amqp.Map{
+			"b":              true,
+			amqp.Symbol("a"): amqp.Char('x'),
+			int32(7):         "seven",
+		},
+		want: `{"7":"seven","a":"x","b":true}`,
+	}, {
+		name: "nested",
+		value: amqp.Map{
+			"when": time.Date(2018, 11, 20, 13, 0, 0, 0, time.UTC),
+			"id":   amqp.UUID{0xc4, 0xb0, 0x4c, 0x04, 0x8a, 0x8e, 0x4a, 0x7d, 0x94, 0x8a, 0x5e, 0x58, 0x43, 0x43, 0x3b, 0x4d},
+			"raw":  amqp.Binary("\x01\x02"),
+			"list": amqp.List{amqp.Map{"x": nil}, []int32{1, 2}},
+		},
+		want: `{"id":"c4b04c04-8a8e-4a7d-948a-5e5843433b4d","list":[{"x":null},[1,2]],"raw":"AQI=","when":"2018-11-20T13:00:00Z"}`,
+	}, {
+		name:  "described",
+		value: amqp.Described{Descriptor: amqp.Symbol("com.example:point"), Value: amqp.List{1, 2}},
+		want:  `{"descriptor":"com.example:point","value":[1,2]}`,
+	}, {
+		name:    "duplicate keys",
+		value:   amqp.Map{"1": "a", int64(1): "b"},
+		wantErr: true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := valueJSON(test.value)
+			if test.wantErr {
+				if err == nil {
+					t.Errorf("valueJSON() = %s, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("valueJSON() error = %v", err)
+			}
+			if string(got) != test.want {
+				t.Errorf("valueJSON() = %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestMessageEventsBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     interface{}
+		inferred bool
+		wantType string
+		wantData string
+	}{{
+		name:     "data section",
+		body:     amqp.Binary("a\x00b"),
+		inferred: true,
+		wantType: "application/octet-stream",
+		wantData: "a\x00b",
+	}, {
+		name:     "string value",
+		body:     "text",
+		wantType: "text/plain; charset=utf-8",
+		wantData: "text",
+	}, {
+		name:     "amqp sequence",
+		body:     amqp.List{int32(1), "two", amqp.Binary("3")},
+		inferred: true,
+		wantType: "application/json",
+		wantData: `[1,"two","Mw=="]`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := amqp.NewMessage()
+			m.Marshal(test.body)
+			m.SetInferred(test.inferred)
+			a := &Adapter{}
+			events, err := a.messageEvents(&m)
+			if err != nil {
+				t.Fatalf("messageEvents() error = %v", err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("messageEvents() = %d events, want 1", len(events))
+			}
+			if got := events[0].ctx.ContentType; got != test.wantType {
+				t.Errorf("content type = %q, want %q", got, test.wantType)
+			}
+			if got := string(events[0].data); got != test.wantData {
+				t.Errorf("data = %q, want %q", got, test.wantData)
+			}
+		})
+	}
+}
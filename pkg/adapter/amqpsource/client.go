@@ -0,0 +1,161 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// SinkClientConfig configures the HTTP client shared by all deliveries to
+// the sink.
+type SinkClientConfig struct {
+	// Timeout limits each request, including reading the response.  0
+	// leaves only the retry policy's AttemptTimeout.
+	Timeout time.Duration
+	// Maximum idle (keep-alive) connections in total and per sink host.
+	// Default to 100 and Credit.
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	// How long an idle connection is kept open.  Defaults to 90 seconds.
+	IdleConnTimeout time.Duration
+	// Use HTTP/1.1 even if an https sink supports HTTP/2.
+	DisableHTTP2 bool
+	// Optional directory holding the TLS files for https sinks: ca.crt,
+	// the CA certificate(s) to verify the sink with instead of the system
+	// roots, and tls.crt and tls.key, a client certificate and key.
+	TLSPath string
+}
+
+const (
+	defaultMaxIdleConns    = 100
+	defaultIdleConnTimeout = 90 * time.Second
+
+	sinkCAFile   = "ca.crt"
+	sinkCertFile = "tls.crt"
+	sinkKeyFile  = "tls.key"
+)
+
+// newSinkClient creates the HTTP client for deliveries to the sink.
+// credit, the most requests the adapter can have in flight at once, is the
+// default number of idle connections kept per host.
+func newSinkClient(c SinkClientConfig, credit int) (*http.Client, error) {
+	t := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		MaxIdleConns:          c.MaxIdleConns,
+		MaxIdleConnsPerHost:   c.MaxIdleConnsPerHost,
+		IdleConnTimeout:       c.IdleConnTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+	if t.MaxIdleConns <= 0 {
+		t.MaxIdleConns = defaultMaxIdleConns
+	}
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = credit
+	}
+	if t.MaxIdleConnsPerHost <= 0 {
+		t.MaxIdleConnsPerHost = http.DefaultMaxIdleConnsPerHost
+	}
+	if t.IdleConnTimeout <= 0 {
+		t.IdleConnTimeout = defaultIdleConnTimeout
+	}
+
+	tlsConfig, err := sinkTLSConfig(c.TLSPath)
+	if err != nil {
+		return nil, err
+	}
+	t.TLSClientConfig = tlsConfig
+
+	if c.DisableHTTP2 {
+		// A non-nil, empty map turns off the transport's HTTP/2 upgrade.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	} else if err := http2.ConfigureTransport(t); err != nil {
+		// Needed since the transport has a custom dialer and TLS config.
+		return nil, fmt.Errorf("sink client: %s", err)
+	}
+	return &http.Client{Transport: t, Timeout: c.Timeout}, nil
+}
+
+// sinkTLSConfig loads the TLS files in dir.  Each file is optional; a
+// missing directory means the defaults.
+func sinkTLSConfig(dir string) (*tls.Config, error) {
+	config := &tls.Config{}
+	if dir == "" {
+		return config, nil
+	}
+	read := func(name string) ([]byte, error) {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return b, err
+	}
+
+	ca, err := read(sinkCAFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(ca) > 0 {
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("sink client: no certificates in %s", sinkCAFile)
+		}
+	}
+
+	cert, err := read(sinkCertFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := read(sinkKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(cert) > 0 && len(key) > 0:
+		pair, err := tls.X509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("sink client: invalid client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{pair}
+	case len(cert) > 0 || len(key) > 0:
+		return nil, fmt.Errorf("sink client: %s and %s must be provided together", sinkCertFile, sinkKeyFile)
+	}
+	return config, nil
+}
+
+// sinkClient returns the adapter's shared HTTP client, creating it on first
+// use.
+func (a *Adapter) sinkClient() (*http.Client, error) {
+	a.clientOnce.Do(func() {
+		a.client, a.clientErr = newSinkClient(a.SinkClient, a.Credit)
+	})
+	return a.client, a.clientErr
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSinkClientCA(t *testing.T) {
+	sink := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	dir, err := ioutil.TempDir("", "sink-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Without the sink's CA the certificate is not trusted.
+	client, err := newSinkClient(SinkClientConfig{TLSPath: dir}, 1)
+	if err != nil {
+		t.Fatalf("newSinkClient() error = %v", err)
+	}
+	if resp, err := client.Get(sink.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("Get() succeeded with an untrusted certificate")
+	}
+
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: sink.Certificate().Raw})
+	if err := ioutil.WriteFile(filepath.Join(dir, sinkCAFile), ca, 0600); err != nil {
+		t.Fatal(err)
+	}
+	client, err = newSinkClient(SinkClientConfig{TLSPath: dir}, 1)
+	if err != nil {
+		t.Fatalf("newSinkClient() error = %v", err)
+	}
+	resp, err := client.Get(sink.URL)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusAccepted)
+	}
+}
+
+func TestSinkClientInvalidTLS(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+	}{{
+		name:  "CA without certificates",
+		files: map[string]string{sinkCAFile: "not a certificate"},
+	}, {
+		name:  "certificate without key",
+		files: map[string]string{sinkCertFile: "cert"},
+	}, {
+		name:  "invalid key pair",
+		files: map[string]string{sinkCertFile: "cert", sinkKeyFile: "key"},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "sink-tls")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range test.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := newSinkClient(SinkClientConfig{TLSPath: dir}, 1); err == nil {
+				t.Error("newSinkClient() succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"qpid.apache.org/electron"
+)
+
+const (
+	defaultReconnectInitialDelay = time.Second
+	defaultReconnectMaxDelay     = 30 * time.Second
+
+	// stableLinkTime is how long the receiver must stay attached, unless
+	// a message arrives sooner, for the reconnect backoff to be reset.  A
+	// broker that detaches links as soon as they attach is not retried in
+	// a tight loop.
+	stableLinkTime = 10 * time.Second
+)
+
+// ReconnectConfig is the "reconnect" section of connect-config.  Delays are
+// Go duration strings.
+type ReconnectConfig struct {
+	// Delay after the first round of failed connection attempts.  It
+	// doubles after each further round, up to MaxDelay.
+	InitialDelay string `json:"initialDelay"`
+	MaxDelay     string `json:"maxDelay"`
+}
+
+// reconnectPolicy returns the backoff between rounds of connection
+// attempts.
+func (a *Adapter) reconnectPolicy() RetryPolicy {
+	p := RetryPolicy{
+		InitialBackoff: defaultReconnectInitialDelay,
+		MaxBackoff:     defaultReconnectMaxDelay,
+	}
+	if a.config != nil && a.config.Reconnect != nil {
+		if d, err := time.ParseDuration(a.config.Reconnect.InitialDelay); err == nil {
+			p.InitialBackoff = d
+		}
+		if d, err := time.ParseDuration(a.config.Reconnect.MaxDelay); err == nil {
+			p.MaxBackoff = d
+		}
+	}
+	return p.withDefaults()
+}
+
+// hosts returns the brokers to try, as "host:port": the host of u followed
+// by the connect-config failover list, shuffled if configured.
+func (a *Adapter) hosts(u *url.URL) []string {
+	hosts := []string{u.Host}
+	if a.config == nil {
+		return hosts
+	}
+	for _, h := range a.config.Failover {
+		if _, _, err := net.SplitHostPort(h); err != nil {
+			// No port, use the default for the scheme.
+			h = net.JoinHostPort(h, u.Port())
+		}
+		hosts = append(hosts, h)
+	}
+	if a.config.Randomize {
+		rand.Shuffle(len(hosts), func(i, j int) { hosts[i], hosts[j] = hosts[j], hosts[i] })
+	}
+	return hosts
+}
+
+// errStopped is returned by receiveFrom when the adapter is stopped.
+var errStopped = errors.New("adapter stopped")
+
+// connectLoop connects to the first available broker and receives messages
+// until the connection fails, then reconnects.  Each round tries every
+// broker once; rounds are separated by an exponential backoff, which is
+// reset once a receiver has been attached for stableLinkTime or has
+// received a message.  It returns when the adapter is stopped.
+func (a *Adapter) connectLoop(container electron.Container, u *url.URL) {
+	policy := a.reconnectPolicy()
+	first := true
+	for round := 1; ; round++ {
+		for _, host := range a.hosts(u) {
+			if !first {
+				a.metrics.reconnect()
+			}
+			first = false
+			stable, err := a.receiveFrom(container, u, host)
+			if err == errStopped || a.stopped() {
+				return
+			}
+			a.logger().Warnw("Connection failed", "host", host, zap.Error(err))
+			if stable {
+				round = 0
+				break
+			}
+		}
+		if round > 0 {
+			d := policy.backoff(round)
+			a.logger().Infow("Reconnecting", "delay", d.String())
+			select {
+			case <-time.After(d):
+			case <-a.stopCh:
+				return
+			}
+		}
+	}
+}
+
+// stopped returns true once the adapter has been asked to stop.
+func (a *Adapter) stopped() bool {
+	select {
+	case <-a.stopCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// receiveAsync receives messages from r in a goroutine, so the delivery
+// loop can also wait for a stop request.  Once done is closed a message
+// that has been received but not handed over is released.
+func (a *Adapter) receiveAsync(r electron.Receiver, done <-chan struct{}) (<-chan electron.ReceivedMessage, <-chan error) {
+	msgs := make(chan electron.ReceivedMessage)
+	errs := make(chan error, 1)
+	go func() {
+		for {
+			rm, err := r.Receive()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case msgs <- rm:
+			case <-done:
+				a.settleAs(&rm, Release)
+				return
+			}
+		}
+	}()
+	return msgs, errs
+}
+
+// receiveFrom connects to host, attaches the receiver and delivers messages
+// until an error occurs.  It returns true if the receiver was attached for
+// stableLinkTime or received a message.
+func (a *Adapter) receiveFrom(container electron.Container, u *url.URL, host string) (bool, error) {
+	hu := *u
+	hu.Host = host
+	logger := a.logger()
+	logger.Infow("Connecting", "host", host)
+	tcpconn, err := a.dial(&hu)
+	if err != nil {
+		return false, err
+	}
+	amqpconn, err := container.Connection(tcpconn, a.connectionOptions(&hu)...)
+	if err != nil {
+		tcpconn.Close()
+		return false, err
+	}
+	defer a.closeConnections(amqpconn)
+
+	addr := strings.TrimPrefix(u.Path, "/")
+	opts := []electron.LinkOption{electron.Source(addr)}
+	opts = append(opts, electron.Capacity(int(a.Credit)), electron.Prefetch(true))
+	logger.Debugw("Creating receiver", "address", addr, "credit", a.Credit)
+	r, err := amqpconn.Receiver(opts...)
+	if err != nil {
+		return false, err
+	}
+	a.metrics.linkUp(a.Credit)
+	defer a.metrics.linkDown()
+	attached := time.Now()
+	received := false
+	stable := func() bool {
+		return received || time.Since(attached) >= stableLinkTime
+	}
+	if err = a.openDeadLetter(container, amqpconn); err != nil {
+		return stable(), err
+	}
+	a.setReceiver(r)
+	defer a.setReceiver(nil)
+	logger.Infow("Receiving", "address", addr)
+	done := make(chan struct{})
+	defer func() {
+		if done != nil {
+			close(done)
+		}
+	}()
+	msgs, errs := a.receiveAsync(r, done)
+	d := a.newDispatcher()
+	defer d.wait()
+	for {
+		// Check for a stop request first, so no new delivery starts.
+		if a.stopped() {
+			d.releaseBatch()
+			d.wait()
+			// Release the message receiveAsync may hold before the link
+			// is closed.
+			close(done)
+			done = nil
+			a.drain(r)
+			return stable(), errStopped
+		}
+		select {
+		case <-a.stopCh:
+			// Handled at the top of the loop.
+		case err := <-errs:
+			logger.Warnw("Failed to receive", zap.Error(err))
+			d.releaseBatch()
+			return stable(), err
+		case rm := <-msgs:
+			received = true
+			a.metrics.messageReceived()
+			d.dispatch(rm)
+		case <-d.deadline():
+			d.flush()
+		}
+	}
+}
+
+// drain stops delivery on r during shutdown.  Messages the broker has sent
+// but that were not delivered to the sink are released, so they can be
+// redelivered at once, and closing the link stops the flow of credit.  A
+// message still unsettled when the link closes is redelivered by the broker.
+func (a *Adapter) drain(r electron.Receiver) {
+	a.logger().Info("Draining receiver")
+	for {
+		rm, err := r.ReceiveTimeout(0)
+		if err != nil {
+			break
+		}
+		a.metrics.messageReceived()
+		a.settleAs(&rm, Release)
+	}
+	r.Close(nil)
+}
+
+// closeConnections closes the source connection and the dead letter
+// connection, if separate.
+func (a *Adapter) closeConnections(amqpconn electron.Connection) {
+	if a.deadLetterConn != nil {
+		a.deadLetterConn.Close(nil)
+		a.deadLetterConn = nil
+	}
+	a.setDeadLetter(nil)
+	amqpconn.Close(nil)
+}
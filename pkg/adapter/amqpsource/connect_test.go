@@ -0,0 +1,423 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"qpid.apache.org/amqp"
+	"qpid.apache.org/electron"
+)
+
+func TestHosts(t *testing.T) {
+	u, _ := url.Parse("amqps://primary:5671/queue")
+	tests := []struct {
+		name   string
+		config *ConnectConfig
+		want   []string
+	}{{
+		name: "no config",
+		want: []string{"primary:5671"},
+	}, {
+		name:   "ordered failover",
+		config: &ConnectConfig{Failover: []string{"backup:5672", "backup2"}},
+		want:   []string{"primary:5671", "backup:5672", "backup2:5671"},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &Adapter{config: test.config}
+			if diff := cmp.Diff(test.want, a.hosts(u)); diff != "" {
+				t.Errorf("unexpected hosts (-want, +got) = %v", diff)
+			}
+		})
+	}
+
+	a := &Adapter{config: &ConnectConfig{Failover: []string{"b:1", "c:1"}, Randomize: true}}
+	got := a.hosts(u)
+	sort.Strings(got)
+	if diff := cmp.Diff([]string{"b:1", "c:1", "primary:5671"}, got); diff != "" {
+		t.Errorf("unexpected randomized hosts (-want, +got) = %v", diff)
+	}
+}
+
+func TestReconnectPolicy(t *testing.T) {
+	a := &Adapter{config: &ConnectConfig{
+		Reconnect: &ReconnectConfig{InitialDelay: "2s", MaxDelay: "1m"},
+	}}
+	p := a.reconnectPolicy()
+	if p.InitialBackoff != 2*time.Second || p.MaxBackoff != time.Minute {
+		t.Errorf("reconnectPolicy() = %+v, want 2s initial and 1m max backoff", p)
+	}
+	p = (&Adapter{}).reconnectPolicy()
+	if p.InitialBackoff != defaultReconnectInitialDelay || p.MaxBackoff != defaultReconnectMaxDelay {
+		t.Errorf("reconnectPolicy() = %+v, want defaults", p)
+	}
+}
+
+// fakeBroker accepts TCP connections for a fakeContainer, which opens an
+// AMQP connection on each whose receiver is made by newReceiver.
+type fakeBroker struct {
+	electron.Container
+	listener    net.Listener
+	newReceiver func() electron.Receiver
+	// Error opening senders, or nil to open a fakeSender.
+	senderErr   error
+	mu          sync.Mutex
+	connections int
+}
+
+func newFakeBroker(t *testing.T, newReceiver func() electron.Receiver) *fakeBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	return &fakeBroker{listener: l, newReceiver: newReceiver}
+}
+
+func (b *fakeBroker) URL() *url.URL {
+	return &url.URL{Scheme: "amqp", Host: b.listener.Addr().String(), Path: "/queue"}
+}
+
+func (b *fakeBroker) Close() {
+	b.listener.Close()
+}
+
+func (b *fakeBroker) count() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.connections
+}
+
+func (b *fakeBroker) Connection(c net.Conn, _ ...electron.ConnectionOption) (electron.Connection, error) {
+	b.mu.Lock()
+	b.connections++
+	b.mu.Unlock()
+	return &fakeConnection{conn: c, receiver: b.newReceiver(), senderErr: b.senderErr}, nil
+}
+
+// connection is electron.Connection under a name that does not clash with
+// its Connection method when embedded.
+type connection electron.Connection
+
+type fakeConnection struct {
+	connection
+	conn      net.Conn
+	receiver  electron.Receiver
+	senderErr error
+}
+
+func (c *fakeConnection) Receiver(...electron.LinkOption) (electron.Receiver, error) {
+	return c.receiver, nil
+}
+
+func (c *fakeConnection) Sender(...electron.LinkOption) (electron.Sender, error) {
+	if c.senderErr != nil {
+		return nil, c.senderErr
+	}
+	return &fakeSender{}, nil
+}
+
+func (c *fakeConnection) Close(error) {
+	c.conn.Close()
+}
+
+// detachingReceiver is a link the broker detaches as soon as it attaches.
+type detachingReceiver struct {
+	electron.Receiver
+}
+
+func (detachingReceiver) Receive() (electron.ReceivedMessage, error) {
+	return electron.ReceivedMessage{}, errors.New("link detached")
+}
+
+func (detachingReceiver) ReceiveTimeout(time.Duration) (electron.ReceivedMessage, error) {
+	return electron.ReceivedMessage{}, errors.New("link detached")
+}
+
+func (detachingReceiver) Close(error) {}
+
+// onceReceiver is a link that delivers one message, then is detached.
+type onceReceiver struct {
+	electron.Receiver
+	sent bool
+}
+
+func (r *onceReceiver) Receive() (electron.ReceivedMessage, error) {
+	if r.sent {
+		return electron.ReceivedMessage{}, errors.New("link detached")
+	}
+	r.sent = true
+	return electron.ReceivedMessage{Message: amqp.NewMessage()}, nil
+}
+
+func TestReceiveFromStable(t *testing.T) {
+	tests := []struct {
+		name     string
+		receiver electron.Receiver
+		want     bool
+	}{{
+		name:     "detached at once",
+		receiver: detachingReceiver{},
+		want:     false,
+	}, {
+		name:     "detached after a message",
+		receiver: &onceReceiver{},
+		want:     true,
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker := newFakeBroker(t, func() electron.Receiver { return test.receiver })
+			defer broker.Close()
+
+			a := &Adapter{
+				Credit:     1,
+				Retry:      RetryPolicy{MaxAttempts: 1},
+				settleFunc: func(*electron.ReceivedMessage, Disposition) error { return nil },
+			}
+			u := broker.URL()
+			stable, err := a.receiveFrom(broker, u, u.Host)
+			if err == nil {
+				t.Fatal("receiveFrom() succeeded, want link error")
+			}
+			if stable != test.want {
+				t.Errorf("receiveFrom() stable = %v, want %v", stable, test.want)
+			}
+		})
+	}
+}
+
+func TestReconnectFlappingLink(t *testing.T) {
+	broker := newFakeBroker(t, func() electron.Receiver { return detachingReceiver{} })
+	defer broker.Close()
+
+	stop := make(chan struct{})
+	a := &Adapter{
+		Credit: 1,
+		stopCh: stop,
+		config: &ConnectConfig{
+			Reconnect: &ReconnectConfig{InitialDelay: "50ms", MaxDelay: "50ms"},
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		a.connectLoop(broker, broker.URL())
+		close(done)
+	}()
+	time.Sleep(300 * time.Millisecond)
+	close(stop)
+	<-done
+
+	// One connection per reconnect delay, not a tight loop.
+	if n := broker.count(); n < 2 || n > 10 {
+		t.Errorf("connected %d times in 300ms with a 50ms reconnect delay", n)
+	}
+}
+
+// queueReceiver is a link with messages ready in msgs.  Receive fails once
+// msgs is closed, or the link is.
+type queueReceiver struct {
+	electron.Receiver
+	msgs      chan electron.ReceivedMessage
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newQueueReceiver(ids ...string) *queueReceiver {
+	r := &queueReceiver{
+		msgs:   make(chan electron.ReceivedMessage, len(ids)),
+		closed: make(chan struct{}),
+	}
+	for _, id := range ids {
+		m := amqp.NewMessage()
+		m.SetMessageId(id)
+		m.SetContentType("text/plain")
+		m.Marshal(amqp.Binary(id))
+		r.msgs <- electron.ReceivedMessage{Message: m}
+	}
+	return r
+}
+
+func (r *queueReceiver) Receive() (electron.ReceivedMessage, error) {
+	select {
+	case rm, ok := <-r.msgs:
+		if ok {
+			return rm, nil
+		}
+		return rm, errors.New("link detached")
+	case <-r.closed:
+		return electron.ReceivedMessage{}, errors.New("link closed")
+	}
+}
+
+func (r *queueReceiver) ReceiveTimeout(time.Duration) (electron.ReceivedMessage, error) {
+	select {
+	case rm, ok := <-r.msgs:
+		if ok {
+			return rm, nil
+		}
+	default:
+	}
+	return electron.ReceivedMessage{}, errors.New("timeout")
+}
+
+func (r *queueReceiver) Close(error) {
+	r.closeOnce.Do(func() { close(r.closed) })
+}
+
+// settlements records the outcome of each message by message-id.
+type settlements struct {
+	mu  sync.Mutex
+	got map[string]Disposition
+	ch  chan struct{}
+}
+
+func newSettlements() *settlements {
+	return &settlements{got: make(map[string]Disposition), ch: make(chan struct{}, 100)}
+}
+
+func (s *settlements) settle(rm *electron.ReceivedMessage, d Disposition) error {
+	s.mu.Lock()
+	s.got[idString(rm.Message.MessageId())] = d
+	s.mu.Unlock()
+	s.ch <- struct{}{}
+	return nil
+}
+
+// wait waits for n messages to be settled and returns the outcomes.
+func (s *settlements) wait(t *testing.T, n int) map[string]Disposition {
+	for i := 0; i < n; i++ {
+		select {
+		case <-s.ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d messages settled, want %d", i, n)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	got := make(map[string]Disposition, len(s.got))
+	for k, v := range s.got {
+		got[k] = v
+	}
+	return got
+}
+
+func TestReceiveErrorReleasesBatch(t *testing.T) {
+	receivers := make(chan electron.Receiver, 2)
+	detaching := newQueueReceiver("1", "2")
+	close(detaching.msgs)
+	receivers <- detaching
+	receivers <- newQueueReceiver()
+	broker := newFakeBroker(t, func() electron.Receiver { return <-receivers })
+	defer broker.Close()
+
+	settled := newSettlements()
+	stop := make(chan struct{})
+	a := &Adapter{
+		SinkURI:     "http://sink.invalid",
+		Credit:      10,
+		BatchSize:   10,
+		BatchWindow: time.Hour,
+		stopCh:      stop,
+		settleFunc:  settled.settle,
+	}
+	done := make(chan struct{})
+	go func() {
+		a.connectLoop(broker, broker.URL())
+		close(done)
+	}()
+	got := settled.wait(t, 2)
+	close(stop)
+	<-done
+
+	if diff := cmp.Diff(map[string]Disposition{"1": Release, "2": Release}, got); diff != "" {
+		t.Errorf("unexpected outcomes (-want, +got) = %v", diff)
+	}
+}
+
+func TestStopMidDelivery(t *testing.T) {
+	posted := make(chan struct{}, 10)
+	unblock := make(chan struct{})
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted <- struct{}{}
+		// Outlast the grace period.
+		select {
+		case <-r.Context().Done():
+		case <-unblock:
+		}
+	}))
+	defer sink.Close()
+	defer close(unblock)
+
+	receiver := newQueueReceiver("1", "2", "3")
+	broker := newFakeBroker(t, func() electron.Receiver { return receiver })
+	defer broker.Close()
+
+	settled := newSettlements()
+	stop := make(chan struct{})
+	a := &Adapter{
+		SinkURI:     sink.URL,
+		Credit:      3,
+		GracePeriod: 50 * time.Millisecond,
+		stopCh:      stop,
+		settleFunc:  settled.settle,
+	}
+	a.abortCtx, a.abort = context.WithCancel(context.Background())
+	defer a.abort()
+	go a.abortAfterGrace()
+	done := make(chan struct{})
+	go func() {
+		a.connectLoop(broker, broker.URL())
+		close(done)
+	}()
+
+	<-posted
+	close(stop)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("adapter did not stop after the grace period")
+	}
+
+	// The delivery cut short, the message handed over but not yet
+	// dispatched, and the message still in the link buffer are released.
+	want := map[string]Disposition{"1": Release, "2": Release, "3": Release}
+	if diff := cmp.Diff(want, settled.wait(t, 3)); diff != "" {
+		t.Errorf("unexpected outcomes (-want, +got) = %v", diff)
+	}
+	if len(posted) != 0 {
+		t.Errorf("%d more messages posted after the stop request", len(posted))
+	}
+}
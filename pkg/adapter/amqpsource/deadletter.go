@@ -0,0 +1,139 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"qpid.apache.org/amqp"
+	"qpid.apache.org/electron"
+)
+
+// Application properties added to a dead-lettered message.
+const (
+	deadLetterReasonProp     = "deadletter-reason"
+	deadLetterStatusProp     = "deadletter-http-status"
+	deadLetterAttemptsProp   = "deadletter-attempts"
+	deadLetterSourceAddrProp = "deadletter-source-address"
+)
+
+// openDeadLetter attaches a sender to the dead letter address, if one is
+// configured.  A plain address is opened on conn, the source's connection.
+// An AMQP URI with a host is opened on a new connection, configured with the
+// same connect-config defaults as the source.
+func (a *Adapter) openDeadLetter(container electron.Container, conn electron.Connection) error {
+	if a.DeadLetterURI == "" {
+		return nil
+	}
+	u, err := a.deadLetterURL()
+	if err != nil {
+		return err
+	}
+	if u.Host != "" {
+		tcpconn, err := a.dialDeadLetter(u)
+		if err != nil {
+			return err
+		}
+		if conn, err = container.Connection(tcpconn, a.connectionOptions(u)...); err != nil {
+			tcpconn.Close()
+			return err
+		}
+		a.deadLetterConn = conn
+	}
+	addr := strings.TrimPrefix(u.Path, "/")
+	if addr == "" {
+		return fmt.Errorf("bad dead letter address %q: no AMQP address", RedactURL(a.DeadLetterURI))
+	}
+	a.logger().Infow("Opening dead letter sender", "address", addr)
+	s, err := conn.Sender(electron.Target(addr))
+	if err != nil {
+		return err
+	}
+	a.setDeadLetter(s)
+	return nil
+}
+
+// deadLetterURL parses the dead letter address.  An AMQP URI takes the
+// connection defaults it lacks from the connect-config already read for the
+// source, leaving the source's settings untouched.
+func (a *Adapter) deadLetterURL() (*url.URL, error) {
+	u, err := parseURL(a.DeadLetterURI)
+	if err != nil {
+		return nil, fmt.Errorf("bad dead letter address: %s", err)
+	}
+	if u.Scheme == "" && u.Host == "" {
+		return u, nil
+	}
+	if a.config != nil {
+		a.config.fillURL(u)
+	}
+	if err = amqp.UpdateURL(u); err != nil {
+		return nil, fmt.Errorf("bad dead letter address: %s", err)
+	}
+	return u, nil
+}
+
+// dialDeadLetter opens a connection to the dead letter broker at u.  It
+// uses the CA, client certificate, TLS versions and cipher suites of the
+// config secret, but not the server name, verification mode or pinned keys,
+// which describe the source's broker.
+func (a *Adapter) dialDeadLetter(u *url.URL) (net.Conn, error) {
+	var settings *TLSConfig
+	if a.config != nil && a.config.TLS != nil {
+		settings = &TLSConfig{
+			MinVersion:   a.config.TLS.MinVersion,
+			MaxVersion:   a.config.TLS.MaxVersion,
+			CipherSuites: a.config.TLS.CipherSuites,
+		}
+	}
+	return a.dialTLS(u, settings)
+}
+
+// sendDeadLetter sends m, annotated with why its delivery to the sink
+// failed, to the dead letter address.  It returns an error unless the dead
+// letter node accepted the message.
+func (a *Adapter) sendDeadLetter(m amqp.Message, cause error) error {
+	// Annotate a copy: the properties may be shared with the received
+	// message.
+	props := make(map[string]interface{}, len(m.ApplicationProperties())+4)
+	for k, v := range m.ApplicationProperties() {
+		props[k] = v
+	}
+	props[deadLetterReasonProp] = cause.Error()
+	if se, ok := cause.(*sinkError); ok {
+		if se.status != 0 {
+			props[deadLetterStatusProp] = int32(se.status)
+		}
+		props[deadLetterAttemptsProp] = int32(se.attempts)
+	} else {
+		props[deadLetterAttemptsProp] = int32(0)
+	}
+	props[deadLetterSourceAddrProp] = a.SpecSource
+	m.SetApplicationProperties(props)
+
+	outcome := a.deadLetterSender().SendSync(m)
+	if outcome.Error != nil {
+		return outcome.Error
+	}
+	if outcome.Status != electron.Accepted {
+		return fmt.Errorf("dead letter address did not accept message: %s", outcome.Status)
+	}
+	return nil
+}
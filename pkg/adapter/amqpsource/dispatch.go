@@ -0,0 +1,201 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"qpid.apache.org/amqp"
+	"qpid.apache.org/electron"
+)
+
+// DeliveryMode selects how received messages are dispatched to the sink.
+type DeliveryMode string
+
+const (
+	// Ordered delivers one message at a time, in the order received.
+	Ordered DeliveryMode = "ordered"
+	// Concurrent delivers up to Credit messages at once.  Each message is
+	// settled as soon as its delivery completes.
+	Concurrent DeliveryMode = "concurrent"
+	// Grouped delivers messages of the same group one at a time, in the
+	// order received, and messages of different groups concurrently.
+	// Messages without a group are delivered as in Concurrent mode.
+	Grouped DeliveryMode = "grouped"
+)
+
+// ParseDeliveryMode converts a mode name to a DeliveryMode.  An empty name
+// selects Ordered.
+func ParseDeliveryMode(s string) (DeliveryMode, error) {
+	switch m := DeliveryMode(s); m {
+	case "":
+		return Ordered, nil
+	case Ordered, Concurrent, Grouped:
+		return m, nil
+	default:
+		return "", fmt.Errorf("bad delivery mode %q: must be %q, %q or %q", s, Ordered, Concurrent, Grouped)
+	}
+}
+
+// dispatcher delivers received messages to the sink and settles them.
+// dispatch is only called from the receiving goroutine.
+type dispatcher struct {
+	a *Adapter
+	// Bounds concurrent deliveries to the link credit.
+	slots chan struct{}
+	// Bounds the groups being delivered in Grouped mode.
+	groupSlots chan struct{}
+	wg         sync.WaitGroup
+
+	mu sync.Mutex
+	// Queued messages of each group being delivered.
+	groups map[string][]electron.ReceivedMessage
+
+	// Messages waiting for their batch to be delivered, and the timer for
+	// the oldest.  Only used by the receiving goroutine.
+	batch  []electron.ReceivedMessage
+	window *time.Timer
+}
+
+func (a *Adapter) newDispatcher() *dispatcher {
+	credit := a.Credit
+	if credit <= 0 {
+		credit = 1
+	}
+	maxGroups := a.MaxGroups
+	if maxGroups <= 0 || maxGroups > credit {
+		maxGroups = credit
+	}
+	return &dispatcher{
+		a:          a,
+		slots:      make(chan struct{}, credit),
+		groupSlots: make(chan struct{}, maxGroups),
+		groups:     make(map[string][]electron.ReceivedMessage),
+	}
+}
+
+// dispatch delivers rm.  In Ordered mode it returns once rm is settled,
+// otherwise it returns once rm is in flight or queued behind its group.
+// With a BatchSize above 1, rm is added to the pending batch instead,
+// whatever the mode.
+func (d *dispatcher) dispatch(rm electron.ReceivedMessage) {
+	if d.a.BatchSize > 1 {
+		d.addToBatch(rm)
+		return
+	}
+	switch d.a.DeliveryMode {
+	case Concurrent:
+		d.dispatchConcurrent(rm)
+	case Grouped:
+		d.dispatchGrouped(rm)
+	default:
+		d.a.deliver(&rm)
+	}
+}
+
+func (d *dispatcher) dispatchConcurrent(rm electron.ReceivedMessage) {
+	d.slots <- struct{}{}
+	d.wg.Add(1)
+	go func() {
+		defer func() {
+			<-d.slots
+			d.wg.Done()
+		}()
+		d.a.deliver(&rm)
+	}()
+}
+
+// dispatchGrouped queues rm behind earlier messages of its group, or
+// starts delivering the group once fewer than MaxGroups are in progress.
+func (d *dispatcher) dispatchGrouped(rm electron.ReceivedMessage) {
+	key, ok := d.a.groupKey(rm.Message)
+	if !ok {
+		d.dispatchConcurrent(rm)
+		return
+	}
+	d.slots <- struct{}{}
+	d.wg.Add(1)
+	d.mu.Lock()
+	if q, active := d.groups[key]; active {
+		d.groups[key] = append(q, rm)
+		d.mu.Unlock()
+		return
+	}
+	d.mu.Unlock()
+
+	// Only this goroutine activates groups, so key is still inactive.
+	d.groupSlots <- struct{}{}
+	d.mu.Lock()
+	d.groups[key] = nil
+	d.mu.Unlock()
+	go d.deliverGroup(key, rm)
+}
+
+// deliverGroup delivers rm and then the messages queued behind it, until
+// the group's queue is empty.
+func (d *dispatcher) deliverGroup(key string, rm electron.ReceivedMessage) {
+	for {
+		d.a.deliver(&rm)
+		<-d.slots
+		d.wg.Done()
+
+		d.mu.Lock()
+		q := d.groups[key]
+		if len(q) == 0 {
+			delete(d.groups, key)
+			d.mu.Unlock()
+			<-d.groupSlots
+			return
+		}
+		rm, d.groups[key] = q[0], q[1:]
+		d.mu.Unlock()
+	}
+}
+
+// wait waits until every dispatched message is settled.
+func (d *dispatcher) wait() {
+	d.wg.Wait()
+}
+
+// deliver posts rm to the sink and settles it.
+func (a *Adapter) deliver(rm *electron.ReceivedMessage) {
+	logger := a.logger()
+	logger.Debugw("Received message", messageFields(rm.Message)...)
+	parent, span := a.startReceive(rm.Message)
+	err := a.postMessage(parent, &rm.Message)
+	if err == nil {
+		logger.Debugw("Message posted", "messageId", idString(rm.Message.MessageId()))
+	}
+	a.settle(rm, err)
+	endSpan(span, err)
+}
+
+// groupKey returns the ordering group of m in Grouped mode: the value of
+// the GroupKey application property if set, otherwise the AMQP group-id.
+func (a *Adapter) groupKey(m amqp.Message) (string, bool) {
+	if a.GroupKey == "" {
+		g := m.GroupId()
+		return g, g != ""
+	}
+	v, ok := m.ApplicationProperties()[a.GroupKey]
+	if !ok || v == nil {
+		return "", false
+	}
+	return fmt.Sprint(v), true
+}
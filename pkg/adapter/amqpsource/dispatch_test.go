@@ -0,0 +1,200 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"qpid.apache.org/amqp"
+	"qpid.apache.org/electron"
+)
+
+func TestGroupKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		groupKey string
+		groupID  string
+		props    map[string]interface{}
+		want     string
+		wantOK   bool
+	}{{
+		name:    "group-id",
+		groupID: "customer-1",
+		want:    "customer-1",
+		wantOK:  true,
+	}, {
+		name: "no group-id",
+	}, {
+		name:     "application property",
+		groupKey: "orderId",
+		groupID:  "ignored",
+		props:    map[string]interface{}{"orderId": int64(42)},
+		want:     "42",
+		wantOK:   true,
+	}, {
+		name:     "missing application property",
+		groupKey: "orderId",
+		groupID:  "ignored",
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := amqp.NewMessage()
+			m.SetGroupId(test.groupID)
+			m.SetApplicationProperties(test.props)
+			a := &Adapter{GroupKey: test.groupKey}
+			got, ok := a.groupKey(m)
+			if got != test.want || ok != test.wantOK {
+				t.Errorf("groupKey() = %q, %v, want %q, %v", got, ok, test.want, test.wantOK)
+			}
+		})
+	}
+}
+
+func TestParseDeliveryMode(t *testing.T) {
+	for in, want := range map[string]DeliveryMode{
+		"":           Ordered,
+		"ordered":    Ordered,
+		"concurrent": Concurrent,
+		"grouped":    Grouped,
+	} {
+		if got, err := ParseDeliveryMode(in); err != nil || got != want {
+			t.Errorf("ParseDeliveryMode(%q) = %q, %v, want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseDeliveryMode("parallel"); err == nil {
+		t.Error("ParseDeliveryMode(\"parallel\") succeeded, want error")
+	}
+}
+
+// slowSink is a sink that holds each request for delay, and records the
+// most requests it had in flight and the bodies in order of arrival.
+type slowSink struct {
+	*httptest.Server
+	delay       time.Duration
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	bodies      []string
+}
+
+func newSlowSink(delay time.Duration) *slowSink {
+	s := &slowSink{delay: delay}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		s.inFlight++
+		if s.inFlight > s.maxInFlight {
+			s.maxInFlight = s.inFlight
+		}
+		s.bodies = append(s.bodies, string(body))
+		s.mu.Unlock()
+
+		time.Sleep(s.delay)
+
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}))
+	return s
+}
+
+// dispatchAll dispatches a message for each body, whose group-id is the
+// body up to its first "-", and waits for all of them to be settled.
+func dispatchAll(a *Adapter, bodies ...string) map[string]Disposition {
+	settled := newSettlements()
+	a.settleFunc = settled.settle
+	d := a.newDispatcher()
+	for _, b := range bodies {
+		m := amqp.NewMessage()
+		m.SetMessageId(b)
+		m.SetGroupId(strings.SplitN(b, "-", 2)[0])
+		m.SetContentType("text/plain")
+		m.Marshal(amqp.Binary(b))
+		d.dispatch(electron.ReceivedMessage{Message: m})
+	}
+	d.wait()
+	settled.mu.Lock()
+	defer settled.mu.Unlock()
+	return settled.got
+}
+
+func TestDispatchConcurrent(t *testing.T) {
+	sink := newSlowSink(20 * time.Millisecond)
+	defer sink.Close()
+
+	a := &Adapter{SinkURI: sink.URL, Credit: 3, DeliveryMode: Concurrent}
+	var bodies []string
+	for i := 0; i < 12; i++ {
+		bodies = append(bodies, fmt.Sprintf("m-%d", i))
+	}
+	got := dispatchAll(a, bodies...)
+
+	if len(got) != len(bodies) {
+		t.Errorf("%d messages settled, want %d", len(got), len(bodies))
+	}
+	for id, d := range got {
+		if d != Accept {
+			t.Errorf("message %s settled as %q, want %q", id, d, Accept)
+		}
+	}
+	// Up to Credit deliveries at once, and more than one.
+	if sink.maxInFlight > a.Credit || sink.maxInFlight < 2 {
+		t.Errorf("sink had up to %d requests in flight, want 2 to %d", sink.maxInFlight, a.Credit)
+	}
+}
+
+func TestDispatchGrouped(t *testing.T) {
+	sink := newSlowSink(5 * time.Millisecond)
+	defer sink.Close()
+
+	a := &Adapter{SinkURI: sink.URL, Credit: 4, DeliveryMode: Grouped}
+	var bodies []string
+	for i := 0; i < 5; i++ {
+		for _, g := range []string{"a", "b", "c"} {
+			bodies = append(bodies, fmt.Sprintf("%s-%d", g, i))
+		}
+	}
+	got := dispatchAll(a, bodies...)
+	if len(got) != len(bodies) {
+		t.Errorf("%d messages settled, want %d", len(got), len(bodies))
+	}
+
+	// Each group arrives in order, the groups concurrently.
+	arrived := make(map[string][]string)
+	for _, b := range sink.bodies {
+		g := strings.SplitN(b, "-", 2)[0]
+		arrived[g] = append(arrived[g], b)
+	}
+	for _, g := range []string{"a", "b", "c"} {
+		want := []string{g + "-0", g + "-1", g + "-2", g + "-3", g + "-4"}
+		if diff := cmp.Diff(want, arrived[g]); diff != "" {
+			t.Errorf("unexpected order of group %s (-want, +got) = %v", g, diff)
+		}
+	}
+	if sink.maxInFlight > 3 || sink.maxInFlight < 2 {
+		t.Errorf("sink had up to %d requests in flight, want 2 to 3", sink.maxInFlight)
+	}
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"fmt"
+
+	"go.uber.org/zap"
+	"qpid.apache.org/electron"
+)
+
+// Disposition is the outcome used to settle an AMQP delivery.
+//
+// The electron API offers accepted, rejected and released outcomes only, so
+// a failed delivery cannot be settled as modified(delivery-failed).
+type Disposition string
+
+const (
+	// Accept settles the delivery as accepted: the message is consumed.
+	Accept Disposition = "accept"
+	// Reject settles the delivery as rejected: the broker will not
+	// redeliver it, but may dead-letter it.
+	Reject Disposition = "reject"
+	// Release settles the delivery as released: the broker may redeliver
+	// it, to this or another receiver.
+	Release Disposition = "release"
+
+	// Defaults for Adapter.PermanentFailure and Adapter.TransientFailure.
+	defaultPermanentFailure = Reject
+	defaultTransientFailure = Release
+)
+
+// ParseDisposition converts a policy name to a Disposition.  An empty name
+// yields the zero Disposition, which selects the adapter default.
+func ParseDisposition(s string) (Disposition, error) {
+	switch d := Disposition(s); d {
+	case "", Accept, Reject, Release:
+		return d, nil
+	default:
+		return "", fmt.Errorf("bad disposition %q: must be %q, %q or %q", s, Accept, Reject, Release)
+	}
+}
+
+// isPermanent returns true if err means the message can never be delivered
+// as is: the sink rejected the event with a client error, or the message
+// could not be converted to an event or sent in a request.
+func isPermanent(err error) bool {
+	if se, ok := err.(*sinkError); ok {
+		return se.permanent()
+	}
+	return true
+}
+
+// disposition returns the outcome for a message whose delivery to the sink
+// ended with err.
+func (a *Adapter) disposition(err error) Disposition {
+	switch {
+	case err == nil:
+		return Accept
+	case isPermanent(err):
+		if a.PermanentFailure != "" {
+			return a.PermanentFailure
+		}
+		return defaultPermanentFailure
+	default:
+		if a.TransientFailure != "" {
+			return a.TransientFailure
+		}
+		return defaultTransientFailure
+	}
+}
+
+// settle settles rm according to the result of delivering it to the sink.
+// If a dead letter address is configured, a failed message is accepted once
+// it has been sent there instead, and released if it could not be.
+func (a *Adapter) settle(rm *electron.ReceivedMessage, err error) {
+	if err != nil && !isPermanent(err) && a.aborted() {
+		// Cut short by shutdown, not a sink failure.
+		a.logger().Infow("Shutdown interrupted delivery, releasing message", zap.Error(err))
+		a.settleAs(rm, Release)
+		return
+	}
+	if err != nil && a.deadLetterSender() != nil {
+		dlerr := a.sendDeadLetter(rm.Message, err)
+		if dlerr == nil {
+			a.logger().Warnw("Failed to post message, sent to dead letter address", zap.Error(err))
+			a.settleAs(rm, Accept)
+			return
+		}
+		// Keep the message rather than settle it as PermanentFailure or
+		// TransientFailure, which may drop it: the dead letter node may be
+		// back by its redelivery.
+		a.logger().Errorw("Failed to send message to dead letter address, releasing message", zap.Error(dlerr))
+		a.settleAs(rm, Release)
+		return
+	}
+	d := a.disposition(err)
+	if err != nil {
+		a.logger().Warnw("Failed to post message", "outcome", string(d), zap.Error(err))
+	}
+	a.settleAs(rm, d)
+}
+
+// settleAs settles rm with the outcome d.
+func (a *Adapter) settleAs(rm *electron.ReceivedMessage, d Disposition) {
+	var serr error
+	switch {
+	case a.settleFunc != nil:
+		serr = a.settleFunc(rm, d)
+	case d == Accept:
+		serr = rm.Accept()
+	case d == Reject:
+		serr = rm.Reject()
+	case d == Release:
+		serr = rm.Release()
+	}
+	if serr != nil {
+		a.logger().Errorw("Failed to settle message", zap.Error(serr))
+	}
+	a.metrics.messageSettled(d)
+}
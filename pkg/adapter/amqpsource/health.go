@@ -0,0 +1,106 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+	"qpid.apache.org/electron"
+)
+
+// healthHandler serves the adapter's probes: /healthz reports that the
+// process is alive, /readyz that it is consuming messages.
+func (a *Adapter) healthHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if err := a.ready(); err != nil {
+			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	return mux
+}
+
+// serveHealth serves the probes on addr.  It runs until the process exits.
+func (a *Adapter) serveHealth(addr string) {
+	go func() {
+		a.logger().Infow("Serving health probes", "address", addr)
+		if err := http.ListenAndServe(addr, a.healthHandler()); err != nil {
+			a.logger().Errorw("Health server failed", zap.Error(err))
+		}
+	}()
+}
+
+// setReceiver records the attached receiver link, or nil once it is
+// detached.
+func (a *Adapter) setReceiver(r electron.Receiver) {
+	a.linksMu.Lock()
+	defer a.linksMu.Unlock()
+	a.receiver = r
+}
+
+// setDeadLetter records the open dead letter sender, or nil once its
+// connection is closed.
+func (a *Adapter) setDeadLetter(s electron.Sender) {
+	a.linksMu.Lock()
+	defer a.linksMu.Unlock()
+	a.deadLetter = s
+}
+
+// deadLetterSender returns the open dead letter sender, or nil if there is
+// none.
+func (a *Adapter) deadLetterSender() electron.Sender {
+	a.linksMu.Lock()
+	defer a.linksMu.Unlock()
+	return a.deadLetter
+}
+
+// ready returns nil if the adapter is consuming messages: the receiver
+// link, and the dead letter sender if one is configured, are open, and the
+// adapter is not shutting down.
+//
+// The AMQP client does not expose the credit of the link, which it renews
+// as messages are received, so the only lack of credit is every message of
+// the credit window waiting for the sink: backpressure, not unreadiness.
+func (a *Adapter) ready() error {
+	if a.stopped() {
+		return errors.New("shutting down")
+	}
+	a.linksMu.Lock()
+	r, dl := a.receiver, a.deadLetter
+	a.linksMu.Unlock()
+	if r == nil {
+		return errors.New("receiver link not attached")
+	}
+	if err := r.Error(); err != nil {
+		// Set when the link, session or connection has closed.
+		return fmt.Errorf("receiver link closed: %s", err)
+	}
+	if dl != nil {
+		if err := dl.Error(); err != nil {
+			return fmt.Errorf("dead letter link closed: %s", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"qpid.apache.org/electron"
+)
+
+// fakeReceiver overrides the electron.Receiver methods the probes use.
+type fakeReceiver struct {
+	electron.Receiver
+	err error
+}
+
+func (r fakeReceiver) Error() error { return r.err }
+
+// fakeDeadLetter overrides the electron.Sender methods the probes use.
+type fakeDeadLetter struct {
+	electron.Sender
+	err error
+}
+
+func (s fakeDeadLetter) Error() error { return s.err }
+
+func TestHealthProbes(t *testing.T) {
+	stopped := make(chan struct{})
+	close(stopped)
+	tests := []struct {
+		name       string
+		receiver   electron.Receiver
+		deadLetter electron.Sender
+		stopCh     <-chan struct{}
+		wantReady  int
+	}{{
+		name:      "not attached",
+		wantReady: http.StatusServiceUnavailable,
+	}, {
+		name:      "attached",
+		receiver:  fakeReceiver{},
+		wantReady: http.StatusOK,
+	}, {
+		name:      "connection closed",
+		receiver:  fakeReceiver{err: errors.New("connection reset")},
+		wantReady: http.StatusServiceUnavailable,
+	}, {
+		name:       "dead letter link open",
+		receiver:   fakeReceiver{},
+		deadLetter: fakeDeadLetter{},
+		wantReady:  http.StatusOK,
+	}, {
+		name:       "dead letter link closed",
+		receiver:   fakeReceiver{},
+		deadLetter: fakeDeadLetter{err: errors.New("link detached")},
+		wantReady:  http.StatusServiceUnavailable,
+	}, {
+		name:      "shutting down",
+		receiver:  fakeReceiver{},
+		stopCh:    stopped,
+		wantReady: http.StatusServiceUnavailable,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &Adapter{stopCh: test.stopCh}
+			a.setDeadLetter(test.deadLetter)
+			a.setReceiver(test.receiver)
+			h := a.healthHandler()
+			for path, want := range map[string]int{"/healthz": http.StatusOK, "/readyz": test.wantReady} {
+				rec := httptest.NewRecorder()
+				h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+				if rec.Code != want {
+					t.Errorf("GET %s = %d, want %d", path, rec.Code, want)
+				}
+			}
+		})
+	}
+}
+
+func TestNotReadyWithoutDeadLetter(t *testing.T) {
+	broker := newFakeBroker(t, func() electron.Receiver { return newQueueReceiver() })
+	broker.senderErr = errors.New("amqp:not-found")
+	defer broker.Close()
+
+	stop := make(chan struct{})
+	a := &Adapter{
+		Credit:        1,
+		DeadLetterURI: "orders.dlq",
+		stopCh:        stop,
+		config: &ConnectConfig{
+			Reconnect: &ReconnectConfig{InitialDelay: "1ms", MaxDelay: "1ms"},
+		},
+	}
+	done := make(chan struct{})
+	go func() {
+		a.connectLoop(broker, broker.URL())
+		close(done)
+	}()
+	for start := time.Now(); time.Since(start) < 100*time.Millisecond; {
+		if err := a.ready(); err == nil {
+			t.Fatal("ready() = nil while the dead letter sender cannot be opened")
+		}
+	}
+	close(stop)
+	<-done
+	if broker.count() < 2 {
+		t.Errorf("connected %d times, want reconnects", broker.count())
+	}
+}
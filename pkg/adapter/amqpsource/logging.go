@@ -0,0 +1,81 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"fmt"
+	"net/url"
+
+	"go.uber.org/zap"
+	"qpid.apache.org/amqp"
+)
+
+// Message bodies, application property values and credentials are never
+// logged: they may hold customer data or secrets.  Per-message logs are at
+// debug level and only identify the message.
+
+const redacted = "xxxxx"
+
+var nopLogger = zap.NewNop().Sugar()
+
+// logger returns the adapter's logger, or a no-op logger if none was set.
+func (a *Adapter) logger() *zap.SugaredLogger {
+	if a.Logger == nil {
+		return nopLogger
+	}
+	return a.Logger
+}
+
+// RedactURL returns s with any password replaced, for logging.
+func RedactURL(s string) string {
+	u, err := url.Parse(s)
+	if err != nil {
+		// The parse error would echo s.
+		return "<invalid URL>"
+	}
+	return redactedURL(u)
+}
+
+func redactedURL(u *url.URL) string {
+	if u.User == nil {
+		return u.String()
+	}
+	r := *u
+	if _, ok := u.User.Password(); ok {
+		r.User = url.UserPassword(u.User.Username(), redacted)
+	}
+	return r.String()
+}
+
+// parseURL is url.Parse without the input, which may hold a password, in
+// the error.
+func parseURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if uerr, ok := err.(*url.Error); ok {
+		return nil, fmt.Errorf("invalid URL: %s", uerr.Err)
+	}
+	return u, err
+}
+
+// messageFields identifies m in log entries.
+func messageFields(m amqp.Message) []interface{} {
+	return []interface{}{
+		"messageId", idString(m.MessageId()),
+		"contentType", m.ContentType(),
+		"bodyType", fmt.Sprintf("%T", m.Body()),
+	}
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+)
+
+// metrics are the adapter's Prometheus metrics, labelled with the name and
+// namespace of the AmqpSource.  All methods are no-ops on a nil *metrics,
+// so an adapter without a metrics endpoint needs no special casing.
+type metrics struct {
+	registry *prometheus.Registry
+
+	received     prometheus.Counter
+	settled      *prometheus.CounterVec
+	unsettled    prometheus.Gauge
+	sinkLatency  *prometheus.HistogramVec
+	bodySize     prometheus.Histogram
+	reconnects   prometheus.Counter
+	credit       prometheus.Gauge
+	linkAttached prometheus.Gauge
+}
+
+func newMetrics(name, namespace string) *metrics {
+	labels := prometheus.Labels{"source_name": name, "source_namespace": namespace}
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		received: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "amqpsource_messages_received_total",
+			Help:        "Messages received from the AMQP source.",
+			ConstLabels: labels,
+		}),
+		settled: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "amqpsource_messages_settled_total",
+			Help:        "Messages settled, by outcome: accepted, rejected or released.",
+			ConstLabels: labels,
+		}, []string{"outcome"}),
+		unsettled: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "amqpsource_messages_unsettled",
+			Help:        "Messages received and not yet settled.",
+			ConstLabels: labels,
+		}),
+		sinkLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "amqpsource_sink_request_duration_seconds",
+			Help:        "Duration of requests to the sink, by status code; 0 if there was no response.",
+			Buckets:     prometheus.DefBuckets,
+			ConstLabels: labels,
+		}, []string{"code"}),
+		bodySize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "amqpsource_message_body_bytes",
+			Help:        "Size of the event data converted from each message.",
+			Buckets:     prometheus.ExponentialBuckets(64, 4, 8),
+			ConstLabels: labels,
+		}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "amqpsource_reconnects_total",
+			Help:        "Connection attempts after the first.",
+			ConstLabels: labels,
+		}),
+		credit: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "amqpsource_link_credit",
+			Help:        "Credit outstanding on the receiver link: the credit window less the messages not yet settled.",
+			ConstLabels: labels,
+		}),
+		linkAttached: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "amqpsource_link_attached",
+			Help:        "1 if the receiver link is attached, 0 otherwise.",
+			ConstLabels: labels,
+		}),
+	}
+	m.registry.MustRegister(m.received, m.settled, m.unsettled, m.sinkLatency,
+		m.bodySize, m.reconnects, m.credit, m.linkAttached)
+	return m
+}
+
+// serve serves the metrics on addr at /metrics.  It runs until the process
+// exits.
+func (m *metrics) serve(addr string, logger *zap.SugaredLogger) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	go func() {
+		logger.Infow("Serving metrics", "address", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			logger.Errorw("Metrics server failed", zap.Error(err))
+		}
+	}()
+}
+
+func (m *metrics) messageReceived() {
+	if m == nil {
+		return
+	}
+	m.received.Inc()
+	m.unsettled.Inc()
+	m.credit.Dec()
+}
+
+// outcomes are the settled outcome label values.
+var outcomes = map[Disposition]string{
+	Accept:  "accepted",
+	Reject:  "rejected",
+	Release: "released",
+}
+
+func (m *metrics) messageSettled(d Disposition) {
+	if m == nil {
+		return
+	}
+	m.settled.WithLabelValues(outcomes[d]).Inc()
+	m.unsettled.Dec()
+	m.credit.Inc()
+}
+
+func (m *metrics) sinkRequest(status int, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.sinkLatency.WithLabelValues(strconv.Itoa(status)).Observe(d.Seconds())
+}
+
+func (m *metrics) messageBody(size int) {
+	if m == nil {
+		return
+	}
+	m.bodySize.Observe(float64(size))
+}
+
+func (m *metrics) reconnect() {
+	if m == nil {
+		return
+	}
+	m.reconnects.Inc()
+}
+
+// linkUp is called when the receiver link is attached with a window of
+// credit.  The electron client does not expose the link's credit, so the
+// gauge tracks the window less the messages received and not yet settled.
+func (m *metrics) linkUp(credit int) {
+	if m == nil {
+		return
+	}
+	m.credit.Set(float64(credit))
+	m.linkAttached.Set(1)
+}
+
+// linkDown is called once every message received on the link has been
+// settled or abandoned with its connection.
+func (m *metrics) linkDown() {
+	if m == nil {
+		return
+	}
+	m.linkAttached.Set(0)
+	m.unsettled.Set(0)
+	m.credit.Set(0)
+}
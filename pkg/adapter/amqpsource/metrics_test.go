@@ -0,0 +1,74 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	m := newMetrics("orders", "default")
+	m.linkUp(10)
+	m.messageReceived()
+	m.messageReceived()
+	m.messageSettled(Accept)
+	m.sinkRequest(http.StatusAccepted, 20*time.Millisecond)
+	m.messageBody(100)
+	m.reconnect()
+
+	rec := httptest.NewRecorder()
+	promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}).ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body := rec.Body.String()
+	for _, want := range []string{
+		`amqpsource_messages_received_total{source_name="orders",source_namespace="default"} 2`,
+		`amqpsource_messages_settled_total{outcome="accepted",source_name="orders",source_namespace="default"} 1`,
+		`amqpsource_messages_unsettled{source_name="orders",source_namespace="default"} 1`,
+		`amqpsource_sink_request_duration_seconds_count{code="202",source_name="orders",source_namespace="default"} 1`,
+		`amqpsource_message_body_bytes_count{source_name="orders",source_namespace="default"} 1`,
+		`amqpsource_reconnects_total{source_name="orders",source_namespace="default"} 1`,
+		`amqpsource_link_credit{source_name="orders",source_namespace="default"} 9`,
+		`amqpsource_link_attached{source_name="orders",source_namespace="default"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics missing %s", want)
+		}
+	}
+
+	m.linkDown()
+	if got := testutil.ToFloat64(m.credit); got != 0 {
+		t.Errorf("credit after linkDown = %v, want 0", got)
+	}
+}
+
+func TestNilMetrics(t *testing.T) {
+	// An adapter without a metrics endpoint has nil metrics.
+	var m *metrics
+	m.linkUp(10)
+	m.messageReceived()
+	m.messageSettled(Release)
+	m.sinkRequest(0, time.Second)
+	m.messageBody(0)
+	m.reconnect()
+	m.linkDown()
+}
@@ -0,0 +1,230 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"qpid.apache.org/amqp"
+)
+
+// ceContextAttributes are the attribute names an extension may not use.
+var ceContextAttributes = map[string]bool{
+	"specversion":     true,
+	"id":              true,
+	"type":            true,
+	"source":          true,
+	"subject":         true,
+	"time":            true,
+	"datacontenttype": true,
+	"dataschema":      true,
+	"data":            true,
+	"data_base64":     true,
+}
+
+// propertyExtensions converts the application properties of m into
+// CloudEvent extension attributes, subject to the adapter's allow and deny
+// lists.  Properties that are part of a binary mode CloudEvent, that have
+// no valid extension name, or whose value has no CloudEvents equivalent are
+// skipped.  When two properties sanitize to the same name, the one whose
+// original name sorts first wins.
+func (a *Adapter) propertyExtensions(m amqp.Message) map[string]interface{} {
+	props := m.ApplicationProperties()
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ext map[string]interface{}
+	for _, k := range keys {
+		if strings.HasPrefix(k, cePropPrefix) || strings.HasPrefix(k, ceLegacyPropPrefix) {
+			continue
+		}
+		if !a.propertyAllowed(k) {
+			continue
+		}
+		name := ceExtensionName(k)
+		if name == "" || ceContextAttributes[name] {
+			continue
+		}
+		if _, dup := ext[name]; dup {
+			continue
+		}
+		v, ok := ceExtensionValue(props[k])
+		if !ok {
+			continue
+		}
+		if ext == nil {
+			ext = make(map[string]interface{})
+		}
+		ext[name] = v
+	}
+	return ext
+}
+
+// propertyAllowed applies the allow and deny lists to an application
+// property name.  An empty allow list allows everything; deny wins.
+func (a *Adapter) propertyAllowed(name string) bool {
+	for _, d := range a.PropertyDeny {
+		if d == name {
+			return false
+		}
+	}
+	if len(a.PropertyAllow) == 0 {
+		return true
+	}
+	for _, p := range a.PropertyAllow {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ceExtensionName sanitizes an AMQP property name to the CloudEvents
+// attribute naming rules: lower case ASCII letters and digits only.
+func ceExtensionName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// ceExtensionValue coerces an AMQP value to a CloudEvents attribute type:
+//
+//	string, symbol, char           String
+//	bool                           Boolean
+//	signed/unsigned integers       Integer (as string if out of int32 range)
+//	float, double                  String
+//	timestamp                      Timestamp (RFC 3339 string)
+//	uuid                           String
+//	binary                         Binary (base64 string)
+//
+// Other types (null, maps, lists, described types) return false.
+func ceExtensionValue(v interface{}) (interface{}, bool) {
+	switch t := v.(type) {
+	case string:
+		return t, true
+	case amqp.Symbol:
+		return string(t), true
+	case amqp.Char:
+		return string(rune(t)), true
+	case bool:
+		return t, true
+	case int8:
+		return int32(t), true
+	case int16:
+		return int32(t), true
+	case int32:
+		return t, true
+	case uint8:
+		return int32(t), true
+	case uint16:
+		return int32(t), true
+	case int:
+		return ceInteger(int64(t)), true
+	case int64:
+		return ceInteger(t), true
+	case uint32:
+		return ceInteger(int64(t)), true
+	case uint:
+		if uint64(t) > math.MaxInt64 {
+			return fmt.Sprint(t), true
+		}
+		return ceInteger(int64(t)), true
+	case uint64:
+		if t > math.MaxInt64 {
+			return fmt.Sprint(t), true
+		}
+		return ceInteger(int64(t)), true
+	case float32:
+		return fmt.Sprint(t), true
+	case float64:
+		return fmt.Sprint(t), true
+	case time.Time:
+		return t.UTC().Format(time.RFC3339Nano), true
+	case amqp.UUID:
+		return uuidString(t), true
+	case amqp.Binary:
+		return base64.StdEncoding.EncodeToString([]byte(t)), true
+	default:
+		return nil, false
+	}
+}
+
+// ceInteger returns i as a CloudEvents Integer, which is limited to 32 bits,
+// or as its decimal string if it does not fit.
+func ceInteger(i int64) interface{} {
+	if i < math.MinInt32 || i > math.MaxInt32 {
+		return fmt.Sprint(i)
+	}
+	return int32(i)
+}
+
+// uuidString formats u in the canonical 8-4-4-4-12 form.
+func uuidString(u amqp.UUID) string {
+	s := u.String()
+	// s formatted as "UUID(c4b04c04-8a8e-4a7d-948a-5e5843433b4d)" , strip enclosing "UUID()" notation
+	return s[5 : len(s)-1]
+}
+
+// messageExtensions returns the extensions for an event created from m: its
+// application properties plus the AMQP standard message properties.  The
+// subject property maps to the subject attribute, which the 0.1 event
+// context only supports as an extension.  The others use "amqp" prefixed
+// names and take precedence over application properties of the same name.
+func (a *Adapter) messageExtensions(m amqp.Message) map[string]interface{} {
+	ext := a.propertyExtensions(m)
+	set := func(name string, v interface{}) {
+		if ext == nil {
+			ext = make(map[string]interface{})
+		}
+		ext[name] = v
+	}
+	if s := m.Subject(); s != "" {
+		set("subject", s)
+	}
+	if id := idString(m.CorrelationId()); id != "" {
+		set("amqpcorrelationid", id)
+	}
+	if s := m.ReplyTo(); s != "" {
+		set("amqpreplyto", s)
+	}
+	if s := m.UserId(); s != "" {
+		set("amqpuserid", s)
+	}
+	if s := m.GroupId(); s != "" {
+		set("amqpgroupid", s)
+		set("amqpgroupsequence", m.GroupSequence())
+	}
+	if s := m.Address(); s != "" {
+		set("amqpto", s)
+	}
+	if s := m.ContentEncoding(); s != "" {
+		set("amqpcontentencoding", s)
+	}
+	return ext
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"qpid.apache.org/amqp"
+)
+
+func TestPropertyExtensions(t *testing.T) {
+	props := map[string]interface{}{
+		"Region":                  "emea",
+		"x-opt-priority":          int64(5),
+		"big":                     uint64(1) << 40,
+		"urgent":                  true,
+		"created":                 time.Date(2018, 11, 20, 13, 0, 0, 0, time.UTC),
+		"raw":                     amqp.Binary("\x01\x02"),
+		"ratio":                   0.5,
+		"nested":                  amqp.Map{"a": "b"},
+		"id":                      "clash",
+		"password":                "secret",
+		"cloudEvents_specversion": "1.0",
+	}
+	tests := []struct {
+		name  string
+		allow []string
+		deny  []string
+		want  map[string]interface{}
+	}{{
+		name: "all",
+		deny: []string{"password"},
+		want: map[string]interface{}{
+			"region":       "emea",
+			"xoptpriority": int32(5),
+			"big":          "1099511627776",
+			"urgent":       true,
+			"created":      "2018-11-20T13:00:00Z",
+			"raw":          "AQI=",
+			"ratio":        "0.5",
+		},
+	}, {
+		name:  "allow list",
+		allow: []string{"Region", "password"},
+		deny:  []string{"password"},
+		want:  map[string]interface{}{"region": "emea"},
+	}, {
+		name:  "none allowed",
+		allow: []string{"missing"},
+		want:  nil,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := &Adapter{PropertyAllow: test.allow, PropertyDeny: test.deny}
+			m := amqp.NewMessage()
+			m.SetApplicationProperties(props)
+			got := a.propertyExtensions(m)
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected extensions (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestMessageExtensions(t *testing.T) {
+	m := amqp.NewMessage()
+	m.SetSubject("order-7")
+	m.SetCorrelationId(uint64(42))
+	m.SetReplyTo("replies")
+	m.SetUserId("alice")
+	m.SetGroupId("customer-1")
+	m.SetGroupSequence(3)
+	m.SetAddress("orders")
+	m.SetContentEncoding("gzip")
+	m.SetApplicationProperties(map[string]interface{}{
+		"amqpto": "shadowed",
+		"region": "emea",
+	})
+	want := map[string]interface{}{
+		"subject":             "order-7",
+		"amqpcorrelationid":   "42",
+		"amqpreplyto":         "replies",
+		"amqpuserid":          "alice",
+		"amqpgroupid":         "customer-1",
+		"amqpgroupsequence":   int32(3),
+		"amqpto":              "orders",
+		"amqpcontentencoding": "gzip",
+		"region":              "emea",
+	}
+	got := (&Adapter{}).messageExtensions(m)
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("unexpected extensions (-want, +got) = %v", diff)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/knative/pkg/cloudevents"
+	"go.uber.org/zap"
+)
+
+// RetryPolicy controls how delivery of an event to the sink is retried
+// before the AMQP delivery is settled as a failure.  Zero fields take the
+// defaults below.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of delivery attempts, including
+	// the first.  1 disables retries.
+	MaxAttempts int
+	// InitialBackoff is the delay before the first retry.  It doubles
+	// for each further retry, up to MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// JitterPercent is the percentage of each backoff that is randomized,
+	// so that adapter replicas do not retry in lock step.  Nil selects the
+	// default; 0 disables jitter.
+	JitterPercent *int
+	// AttemptTimeout limits each delivery attempt.
+	AttemptTimeout time.Duration
+}
+
+const (
+	defaultMaxAttempts    = 5
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultJitterPercent  = 20
+	defaultAttemptTimeout = 30 * time.Second
+)
+
+// withDefaults returns p with zero fields replaced by their defaults.
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = defaultMaxAttempts
+	}
+	if p.InitialBackoff <= 0 {
+		p.InitialBackoff = defaultInitialBackoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = defaultMaxBackoff
+	}
+	if p.MaxBackoff < p.InitialBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	jitter := defaultJitterPercent
+	if p.JitterPercent != nil {
+		jitter = *p.JitterPercent
+	}
+	if jitter < 0 {
+		jitter = 0
+	} else if jitter > 100 {
+		jitter = 100
+	}
+	p.JitterPercent = &jitter
+	if p.AttemptTimeout <= 0 {
+		p.AttemptTimeout = defaultAttemptTimeout
+	}
+	return p
+}
+
+// backoff returns the delay before retry number n (1 for the first retry).
+func (p RetryPolicy) backoff(n int) time.Duration {
+	d := p.InitialBackoff
+	for i := 1; i < n && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.JitterPercent == nil {
+		return d
+	}
+	if jitter := int64(d) * int64(*p.JitterPercent) / 100; jitter > 0 {
+		d -= time.Duration(rand.Int63n(jitter + 1))
+	}
+	return d
+}
+
+// postEvent sends a single event to the sink, retrying transient failures
+// according to the adapter's retry policy.  The error of the last attempt
+// is returned.
+func (a *Adapter) postEvent(parent context.Context, ctx cloudevents.EventContext, data []byte) error {
+	return a.post(parent, "event "+ctx.EventID, func(reqctx context.Context) (*http.Request, error) {
+		return a.newRequest(eventTraceContext(reqctx, ctx), data)
+	})
+}
+
+// post sends the requests made by newRequest to the sink until one
+// succeeds, fails permanently or the retry policy gives up.  what describes
+// the request in log messages.  Retries stop when parent is done.
+func (a *Adapter) post(parent context.Context, what string, newRequest func(context.Context) (*http.Request, error)) error {
+	p := a.Retry.withDefaults()
+	for attempt := 1; ; attempt++ {
+		err := a.send(parent, newRequest, p.AttemptTimeout)
+		if se, ok := err.(*sinkError); ok {
+			se.attempts = attempt
+		}
+		if err == nil || isPermanent(err) || attempt >= p.MaxAttempts {
+			return err
+		}
+		d := p.backoff(attempt)
+		a.logger().Infow("Failed to post, retrying", "request", what, "attempt", attempt, "delay", d.String(), zap.Error(err))
+		select {
+		case <-time.After(d):
+		case <-parent.Done():
+			return err
+		}
+	}
+}
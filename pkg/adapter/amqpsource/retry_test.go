@@ -0,0 +1,115 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/knative/pkg/cloudevents"
+)
+
+func TestBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+		JitterPercent:  intPtr(50),
+	}.withDefaults()
+	tests := []struct {
+		retry    int
+		min, max time.Duration
+	}{
+		{1, 50 * time.Millisecond, 100 * time.Millisecond},
+		{2, 100 * time.Millisecond, 200 * time.Millisecond},
+		{4, 400 * time.Millisecond, 800 * time.Millisecond},
+		{5, 500 * time.Millisecond, time.Second},
+		{50, 500 * time.Millisecond, time.Second},
+	}
+	for _, test := range tests {
+		for i := 0; i < 20; i++ {
+			if d := p.backoff(test.retry); d < test.min || d > test.max {
+				t.Errorf("backoff(%d) = %s, want %s to %s", test.retry, d, test.min, test.max)
+			}
+		}
+	}
+}
+
+func TestBackoffWithoutJitter(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		JitterPercent:  intPtr(0),
+	}.withDefaults()
+	for i := 0; i < 20; i++ {
+		if d := p.backoff(2); d != 200*time.Millisecond {
+			t.Fatalf("backoff(2) = %s, want 200ms", d)
+		}
+	}
+}
+
+func intPtr(i int) *int {
+	return &i
+}
+
+func TestPostEventRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantAttempts int
+		wantErr      bool
+	}{{
+		name:         "transient failures then success",
+		statuses:     []int{503, 429, 202},
+		wantAttempts: 3,
+	}, {
+		name:         "permanent failure is not retried",
+		statuses:     []int{400},
+		wantAttempts: 1,
+		wantErr:      true,
+	}, {
+		name:         "attempts exhausted",
+		statuses:     []int{500, 500, 500, 500},
+		wantAttempts: 3,
+		wantErr:      true,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts := 0
+			sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(test.statuses[attempts])
+				attempts++
+			}))
+			defer sink.Close()
+
+			a := &Adapter{
+				SinkURI: sink.URL,
+				Retry:   RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+			}
+			ctx := cloudevents.EventContext{EventID: "1", EventType: "t", Source: "s"}
+			err := a.postEvent(context.Background(), ctx, []byte("hello"))
+			if (err != nil) != test.wantErr {
+				t.Errorf("postEvent() error = %v, wantErr %v", err, test.wantErr)
+			}
+			if attempts != test.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, test.wantAttempts)
+			}
+		})
+	}
+}
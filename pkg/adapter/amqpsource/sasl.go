@@ -0,0 +1,117 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"qpid.apache.org/electron"
+)
+
+// saslMechanisms are the mechanisms the "sasl" section may allow.  The
+// SCRAM mechanisms need a Qpid Proton built with Cyrus SASL.
+var saslMechanisms = map[string]bool{
+	"ANONYMOUS":     true,
+	"PLAIN":         true,
+	"EXTERNAL":      true,
+	"SCRAM-SHA-1":   true,
+	"SCRAM-SHA-256": true,
+	"SCRAM-SHA-512": true,
+}
+
+// SASLConfig is the "sasl" section of connect-config.
+type SASLConfig struct {
+	// Mechanisms the adapter may authenticate with, chosen from those the
+	// broker offers.  Empty allows any mechanism Proton supports.
+	Mechanisms []string `json:"mechanisms"`
+	// Allow mechanisms that send the password in the clear, such as
+	// PLAIN, on connections without TLS.
+	AllowInsecure bool `json:"allowInsecure"`
+	// AuthorizationID is rejected: the Qpid electron client cannot send a
+	// SASL authorization identity, and connecting as the authenticated
+	// user instead could grant the adapter other rights than intended.
+	AuthorizationID *string `json:"authorizationId"`
+}
+
+// validate checks the mechanisms and normalizes them to upper case, and
+// rejects an authorization identity.
+func (c *SASLConfig) validate() error {
+	if c.AuthorizationID != nil {
+		return fmt.Errorf("SASL authorizationId is not supported")
+	}
+	for i, m := range c.Mechanisms {
+		m = strings.ToUpper(strings.TrimSpace(m))
+		if !saslMechanisms[m] {
+			return fmt.Errorf("unknown SASL mechanism %q", c.Mechanisms[i])
+		}
+		c.Mechanisms[i] = m
+	}
+	return nil
+}
+
+// saslSettings are the credentials and SASL settings an AMQP connection is
+// opened with.
+type saslSettings struct {
+	user          string
+	password      []byte // nil if there is none
+	mechanisms    []string
+	allowInsecure bool
+}
+
+// saslSettings returns the settings to connect to u with: the user and
+// password in u, and the SASL section of connect-config.  With a client
+// certificate and no mechanisms configured, only EXTERNAL is allowed.
+func (a *Adapter) saslSettings(u *url.URL) saslSettings {
+	var s saslSettings
+	if u.User != nil && u.User.Username() != "" {
+		s.user = u.User.Username()
+		if p, ok := u.User.Password(); ok {
+			s.password = []byte(p)
+		}
+	}
+	if a.config != nil && a.config.SASL != nil {
+		s.mechanisms = a.config.SASL.Mechanisms
+		s.allowInsecure = a.config.SASL.AllowInsecure
+	}
+	if len(s.mechanisms) == 0 && a.ClientCert != nil {
+		// The broker identifies the adapter by its certificate.
+		s.mechanisms = []string{"EXTERNAL"}
+	}
+	return s
+}
+
+// connectionOptions returns the options to open an AMQP connection to u
+// with, from saslSettings.
+func (a *Adapter) connectionOptions(u *url.URL) []electron.ConnectionOption {
+	s := a.saslSettings(u)
+	var opts []electron.ConnectionOption
+	if s.user != "" {
+		opts = append(opts, electron.User(s.user))
+		if s.password != nil {
+			opts = append(opts, electron.Password(s.password))
+		}
+	}
+	if a.config != nil && a.config.SASL != nil {
+		opts = append(opts, electron.SASLAllowInsecure(s.allowInsecure))
+	}
+	if len(s.mechanisms) > 0 {
+		opts = append(opts, electron.SASLAllowedMechs(strings.Join(s.mechanisms, " ")))
+	}
+	return opts
+}
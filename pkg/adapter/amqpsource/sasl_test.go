@@ -0,0 +1,155 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSASLConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		want    *SASLConfig
+		wantErr bool
+	}{{
+		name:   "no sasl section",
+		config: `{"host": "broker"}`,
+	}, {
+		name:   "mechanisms",
+		config: `{"sasl": {"mechanisms": ["scram-sha-256", "PLAIN"], "allowInsecure": true}}`,
+		want: &SASLConfig{
+			Mechanisms:    []string{"SCRAM-SHA-256", "PLAIN"},
+			AllowInsecure: true,
+		},
+	}, {
+		name:    "unknown mechanism",
+		config:  `{"sasl": {"mechanisms": ["GSSAPI"]}}`,
+		wantErr: true,
+	}, {
+		name:    "authorization identity",
+		config:  `{"sasl": {"mechanisms": ["PLAIN"], "authorizationId": "orders"}}`,
+		wantErr: true,
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := parseConfigBytes([]byte(tc.config))
+			if tc.wantErr {
+				if err == nil {
+					t.Fatal("parseConfigBytes succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseConfigBytes: %v", err)
+			}
+			if diff := cmp.Diff(tc.want, config.SASL); diff != "" {
+				t.Errorf("unexpected sasl section (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestSASLSettings(t *testing.T) {
+	cert, key := newClientCert(t, "")
+	tests := []struct {
+		name  string
+		url   string
+		files map[string][]byte
+		want  saslSettings
+	}{{
+		name: "no credentials",
+		url:  "amqp://broker/queue",
+	}, {
+		name: "user and password from config",
+		url:  "amqp://broker/queue",
+		files: map[string][]byte{
+			"connect-config": []byte(`{"user": "source", "password": "secret"}`),
+		},
+		want: saslSettings{user: "source", password: []byte("secret")},
+	}, {
+		name: "user and password in url win",
+		url:  "amqp://admin:pw@broker/queue",
+		files: map[string][]byte{
+			"connect-config": []byte(`{"user": "source", "password": "secret"}`),
+		},
+		want: saslSettings{user: "admin", password: []byte("pw")},
+	}, {
+		name: "user without password",
+		url:  "amqp://admin@broker/queue",
+		want: saslSettings{user: "admin"},
+	}, {
+		name: "mechanisms from config",
+		url:  "amqp://broker/queue",
+		files: map[string][]byte{
+			"connect-config": []byte(`{"user": "source", "password": "secret",
+				"sasl": {"mechanisms": ["plain"], "allowInsecure": true}}`),
+		},
+		want: saslSettings{
+			user:          "source",
+			password:      []byte("secret"),
+			mechanisms:    []string{"PLAIN"},
+			allowInsecure: true,
+		},
+	}, {
+		name:  "client certificate forces EXTERNAL",
+		url:   "amqps://broker/queue",
+		files: map[string][]byte{clientCertFile: cert, clientKeyFile: key},
+		want:  saslSettings{mechanisms: []string{"EXTERNAL"}},
+	}, {
+		name: "configured mechanisms win over client certificate",
+		url:  "amqps://broker/queue",
+		files: map[string][]byte{
+			"connect-config": []byte(`{"sasl": {"mechanisms": ["SCRAM-SHA-256"]}}`),
+			clientCertFile:   cert,
+			clientKeyFile:    key,
+		},
+		want: saslSettings{mechanisms: []string{"SCRAM-SHA-256"}},
+	}}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "amqp-config")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			for name, content := range tc.files {
+				if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			u, err := url.Parse(tc.url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			a := &Adapter{CredsPath: dir}
+			if err := a.applyConfig(u); err != nil {
+				t.Fatalf("applyConfig: %v", err)
+			}
+			got := a.saslSettings(u)
+			if diff := cmp.Diff(tc.want, got, cmp.AllowUnexported(saslSettings{})); diff != "" {
+				t.Errorf("unexpected settings (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/knative/pkg/cloudevents"
+)
+
+// SpecVersion is the CloudEvents specification version of the events sent
+// to the sink.
+type SpecVersion string
+
+const (
+	// SpecVersion10 is CloudEvents 1.0, with ce-specversion, ce-id,
+	// ce-type... headers.
+	SpecVersion10 SpecVersion = "1.0"
+	// SpecVersion01 is CloudEvents 0.1, with CE-CloudEventsVersion,
+	// CE-EventID, CE-EventType... headers, for legacy consumers.
+	SpecVersion01 SpecVersion = "0.1"
+)
+
+// ParseSpecVersion parses a spec version, "" meaning SpecVersion10.
+func ParseSpecVersion(s string) (SpecVersion, error) {
+	switch v := SpecVersion(s); v {
+	case "":
+		return SpecVersion10, nil
+	case SpecVersion10, SpecVersion01:
+		return v, nil
+	default:
+		return "", fmt.Errorf("unsupported CloudEvents spec version %q", s)
+	}
+}
+
+// newBinaryRequest creates a binary content mode HTTP request for an event,
+// with headers for the given spec version.  Unlike
+// cloudevents.Binary.NewRequest, which can only encode JSON and XML, data is
+// used as the request body unchanged, whatever the content type.
+func newBinaryRequest(sinkURI string, version SpecVersion, ctx cloudevents.EventContext, data []byte) (*http.Request, error) {
+	req, err := http.NewRequest(http.MethodPost, sinkURI, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if ctx.ContentType != "" {
+		req.Header.Set("Content-Type", ctx.ContentType)
+	}
+	if version == SpecVersion01 {
+		err = setHeaders01(req.Header, ctx)
+	} else {
+		err = setHeaders10(req.Header, ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// setHeaders10 sets the CloudEvents 1.0 HTTP binding headers for ctx, with
+// their values percent-encoded as the binding requires.  The 0.1
+// eventTypeVersion attribute, which 1.0 dropped, becomes the
+// eventtypeversion extension.
+func setHeaders10(h http.Header, ctx cloudevents.EventContext) error {
+	set := func(name, value string) {
+		h.Set(name, percentEncode(value))
+	}
+	set("ce-specversion", string(SpecVersion10))
+	set("ce-id", ctx.EventID)
+	set("ce-type", ctx.EventType)
+	set("ce-source", ctx.Source)
+	if ctx.EventTypeVersion != "" {
+		set("ce-eventtypeversion", ctx.EventTypeVersion)
+	}
+	if !ctx.EventTime.IsZero() {
+		set("ce-time", ctx.EventTime.UTC().Format(time.RFC3339Nano))
+	}
+	if ctx.SchemaURL != "" {
+		set("ce-dataschema", ctx.SchemaURL)
+	}
+	for k, v := range ctx.Extensions {
+		value, err := headerValue(v)
+		if err != nil {
+			return err
+		}
+		set("ce-"+k, value)
+	}
+	return nil
+}
+
+// percentEncode encodes s for a CloudEvents 1.0 HTTP header: space, '"',
+// '%' and every byte outside printable ASCII are written as %XX, so any
+// UTF-8 value can be sent.
+func percentEncode(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0xf])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// setHeaders01 sets the CloudEvents 0.1 HTTP binding headers for ctx.
+func setHeaders01(h http.Header, ctx cloudevents.EventContext) error {
+	h.Set("CE-CloudEventsVersion", string(SpecVersion01))
+	h.Set("CE-EventID", ctx.EventID)
+	h.Set("CE-EventType", ctx.EventType)
+	h.Set("CE-Source", ctx.Source)
+	if ctx.EventTypeVersion != "" {
+		h.Set("CE-EventTypeVersion", ctx.EventTypeVersion)
+	}
+	if !ctx.EventTime.IsZero() {
+		h.Set("CE-EventTime", ctx.EventTime.UTC().Format(time.RFC3339Nano))
+	}
+	if ctx.SchemaURL != "" {
+		h.Set("CE-SchemaURL", ctx.SchemaURL)
+	}
+	for k, v := range ctx.Extensions {
+		value, err := headerValue(v)
+		if err != nil {
+			return err
+		}
+		h.Set("CE-X-"+k, value)
+	}
+	return nil
+}
+
+// headerValue formats an attribute value for an HTTP header.  Strings are
+// used as is and other values are JSON encoded.
+func headerValue(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+
+// checkHeaders returns an error if h holds a field name or value that
+// cannot be sent, such as a value with a line break.  The HTTP client
+// would refuse the request for it, on every attempt.
+func checkHeaders(h http.Header) error {
+	for name, values := range h {
+		if name == "" || strings.IndexFunc(name, func(r rune) bool { return !isTokenChar(r) }) >= 0 {
+			return fmt.Errorf("invalid HTTP header name %q", name)
+		}
+		for _, v := range values {
+			for i := 0; i < len(v); i++ {
+				if c := v[i]; (c < ' ' && c != '\t') || c == 0x7f {
+					return fmt.Errorf("invalid value for HTTP header %s", name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// isTokenChar returns true if r may appear in an HTTP header name.
+func isTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	}
+	return r < 0x80 && strings.ContainsRune("!#$%&'*+-.^_`|~", r)
+}
+
+// sinkError is a failure to deliver an event to the sink.
+type sinkError struct {
+	// HTTP response status, or 0 if there was no response.
+	status int
+	// Number of delivery attempts made.
+	attempts int
+	err      error
+}
+
+func (e *sinkError) Error() string {
+	return e.err.Error()
+}
+
+// permanent returns true if the sink refused the event with a client error
+// that resending cannot fix.  408 Request Timeout and 429 Too Many Requests
+// are transient, like server errors and failures to get a response.
+func (e *sinkError) permanent() bool {
+	switch {
+	case e.status == http.StatusRequestTimeout, e.status == http.StatusTooManyRequests:
+		return false
+	case e.status >= 300 && e.status < 500:
+		// Includes redirects the client did not follow.
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/pkg/cloudevents"
+	"qpid.apache.org/amqp"
+	"qpid.apache.org/electron"
+)
+
+func TestNewBinaryRequest(t *testing.T) {
+	data := []byte{0x1f, 0x8b, 0x08, 0x00, 0xff, 0x00}
+	ctx := cloudevents.EventContext{
+		CloudEventsVersion: cloudevents.CloudEventsVersion,
+		EventID:            "1",
+		EventType:          "amqp.message.delivery",
+		EventTime:          time.Date(2018, 11, 20, 13, 0, 0, 0, time.UTC),
+		Source:             "amqp://broker:5672/queue",
+		ContentType:        "application/gzip",
+		Extensions: map[string]interface{}{
+			"region":   "emea",
+			"priority": int32(5),
+			"note":     "Grüße 100% \"ok\"",
+		},
+	}
+	tests := []struct {
+		version SpecVersion
+		want    http.Header
+	}{{
+		version: SpecVersion10,
+		want: http.Header{
+			"Ce-Specversion": {"1.0"},
+			"Ce-Id":          {"1"},
+			"Ce-Type":        {"amqp.message.delivery"},
+			"Ce-Time":        {"2018-11-20T13:00:00Z"},
+			"Ce-Source":      {"amqp://broker:5672/queue"},
+			"Content-Type":   {"application/gzip"},
+			"Ce-Region":      {"emea"},
+			"Ce-Priority":    {"5"},
+			"Ce-Note":        {"Gr%C3%BC%C3%9Fe%20100%25%20%22ok%22"},
+		},
+	}, {
+		version: SpecVersion01,
+		want: http.Header{
+			"Ce-Cloudeventsversion": {"0.1"},
+			"Ce-Eventid":            {"1"},
+			"Ce-Eventtype":          {"amqp.message.delivery"},
+			"Ce-Eventtime":          {"2018-11-20T13:00:00Z"},
+			"Ce-Source":             {"amqp://broker:5672/queue"},
+			"Content-Type":          {"application/gzip"},
+			"Ce-X-Region":           {"emea"},
+			"Ce-X-Priority":         {"5"},
+			"Ce-X-Note":             {"Grüße 100% \"ok\""},
+		},
+	}}
+
+	for _, test := range tests {
+		t.Run(string(test.version), func(t *testing.T) {
+			req, err := newBinaryRequest("http://sink.example.com/", test.version, ctx, data)
+			if err != nil {
+				t.Fatalf("newBinaryRequest() error = %v", err)
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			if !bytes.Equal(data, body) {
+				t.Errorf("body = %x, want %x", body, data)
+			}
+			if diff := cmp.Diff(test.want, req.Header); diff != "" {
+				t.Errorf("unexpected headers (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
+
+func TestDisposition(t *testing.T) {
+	tests := []struct {
+		name    string
+		adapter *Adapter
+		err     error
+		want    Disposition
+	}{{
+		name: "delivered",
+		want: Accept,
+	}, {
+		name: "conversion failure",
+		err:  errors.New("AMQP message format not supported"),
+		want: Reject,
+	}, {
+		name: "not found",
+		err:  &sinkError{status: http.StatusNotFound, err: errors.New("404")},
+		want: Reject,
+	}, {
+		name: "too many requests",
+		err:  &sinkError{status: http.StatusTooManyRequests, err: errors.New("429")},
+		want: Release,
+	}, {
+		name: "server error",
+		err:  &sinkError{status: http.StatusInternalServerError, err: errors.New("500")},
+		want: Release,
+	}, {
+		name: "no response",
+		err:  &sinkError{err: errors.New("timeout")},
+		want: Release,
+	}, {
+		name:    "configured policy",
+		adapter: &Adapter{PermanentFailure: Accept, TransientFailure: Reject},
+		err:     &sinkError{status: http.StatusServiceUnavailable, err: errors.New("503")},
+		want:    Reject,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			a := test.adapter
+			if a == nil {
+				a = &Adapter{}
+			}
+			if got := a.disposition(test.err); got != test.want {
+				t.Errorf("disposition() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestDeliverUnsendable(t *testing.T) {
+	var requests int
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+	}))
+	defer sink.Close()
+
+	m := amqp.NewMessage()
+	m.SetMessageId("1")
+	m.SetContentType("text/plain")
+	m.SetApplicationProperties(map[string]interface{}{"note": "two\nlines"})
+	m.Marshal(amqp.Binary("hello"))
+
+	var got []Disposition
+	a := &Adapter{
+		SinkURI:     sink.URL,
+		SpecVersion: SpecVersion01,
+		settleFunc: func(_ *electron.ReceivedMessage, d Disposition) error {
+			got = append(got, d)
+			return nil
+		},
+	}
+	a.deliver(&electron.ReceivedMessage{Message: m})
+	if diff := cmp.Diff([]Disposition{Reject}, got); diff != "" {
+		t.Errorf("unexpected outcomes (-want, +got) = %v", diff)
+	}
+	if requests != 0 {
+		t.Errorf("sink got %d requests, want none", requests)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/knative/pkg/cloudevents"
+)
+
+// ContentMode is the CloudEvents HTTP content mode used to send events to
+// the sink.
+type ContentMode string
+
+const (
+	// Binary sends event attributes as HTTP headers and the data as the
+	// request body.
+	Binary ContentMode = "binary"
+	// Structured sends the whole event as an application/cloudevents+json
+	// envelope in the request body, for sinks that only read the body.
+	Structured ContentMode = "structured"
+)
+
+// ParseContentMode parses a content mode, "" meaning Binary.
+func ParseContentMode(s string) (ContentMode, error) {
+	switch m := ContentMode(s); m {
+	case "":
+		return Binary, nil
+	case Binary, Structured:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown content mode %q", s)
+	}
+}
+
+// newStructuredRequest creates a structured content mode HTTP request for
+// an event.
+func newStructuredRequest(sinkURI string, version SpecVersion, ctx cloudevents.EventContext, data []byte) (*http.Request, error) {
+	b, err := json.Marshal(eventEnvelope(version, ctx, data))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, sinkURI, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", ceStructuredType+"; charset=utf-8")
+	return req, nil
+}
+
+// eventEnvelope returns the JSON format of an event for the given spec
+// version.  JSON data is embedded as is and text data as a string.  Other
+// data is base64 encoded, in data_base64 for 1.0 and in data for 0.1, where
+// the content type tells the consumer how to decode it.
+func eventEnvelope(version SpecVersion, ctx cloudevents.EventContext, data []byte) map[string]interface{} {
+	e := make(map[string]interface{})
+	if version == SpecVersion01 {
+		e["cloudEventsVersion"] = string(SpecVersion01)
+		e["eventID"] = ctx.EventID
+		e["eventType"] = ctx.EventType
+		e["source"] = ctx.Source
+		if ctx.EventTypeVersion != "" {
+			e["eventTypeVersion"] = ctx.EventTypeVersion
+		}
+		if !ctx.EventTime.IsZero() {
+			e["eventTime"] = ctx.EventTime.UTC().Format(time.RFC3339Nano)
+		}
+		if ctx.SchemaURL != "" {
+			e["schemaURL"] = ctx.SchemaURL
+		}
+		if ctx.ContentType != "" {
+			e["contentType"] = ctx.ContentType
+		}
+		if len(ctx.Extensions) > 0 {
+			e["extensions"] = ctx.Extensions
+		}
+	} else {
+		// Extensions first, so they cannot replace context attributes.
+		for k, v := range ctx.Extensions {
+			e[k] = v
+		}
+		e["specversion"] = string(SpecVersion10)
+		e["id"] = ctx.EventID
+		e["type"] = ctx.EventType
+		e["source"] = ctx.Source
+		if ctx.EventTypeVersion != "" {
+			e["eventtypeversion"] = ctx.EventTypeVersion
+		}
+		if !ctx.EventTime.IsZero() {
+			e["time"] = ctx.EventTime.UTC().Format(time.RFC3339Nano)
+		}
+		if ctx.SchemaURL != "" {
+			e["dataschema"] = ctx.SchemaURL
+		}
+		if ctx.ContentType != "" {
+			e["datacontenttype"] = ctx.ContentType
+		}
+	}
+
+	if len(data) == 0 {
+		return e
+	}
+	switch {
+	case isJSON(ctx.ContentType) && json.Valid(data):
+		e["data"] = json.RawMessage(data)
+	case isText(ctx.ContentType) && utf8.Valid(data):
+		e["data"] = string(data)
+	case version == SpecVersion01:
+		e["data"] = base64.StdEncoding.EncodeToString(data)
+	default:
+		e["data_base64"] = base64.StdEncoding.EncodeToString(data)
+	}
+	return e
+}
+
+// isText returns true if contentType is a text or XML type.
+func isText(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || mt == "application/xml" || strings.HasSuffix(mt, "+xml")
+}
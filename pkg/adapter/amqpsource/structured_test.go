@@ -0,0 +1,99 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/pkg/cloudevents"
+)
+
+func TestNewStructuredRequest(t *testing.T) {
+	eventTime := time.Date(2018, 11, 20, 13, 0, 0, 0, time.UTC)
+	ctx := func(contentType string) cloudevents.EventContext {
+		return cloudevents.EventContext{
+			EventID:     "1",
+			EventType:   "amqp.message.delivery",
+			EventTime:   eventTime,
+			Source:      "amqp://broker:5672/queue",
+			ContentType: contentType,
+			Extensions:  map[string]interface{}{"region": "emea"},
+		}
+	}
+	tests := []struct {
+		name    string
+		version SpecVersion
+		ctx     cloudevents.EventContext
+		data    string
+		want    string
+	}{{
+		name:    "JSON data",
+		version: SpecVersion10,
+		ctx:     ctx("application/json"),
+		data:    `{"a":1}`,
+		want: `{"specversion":"1.0","id":"1","type":"amqp.message.delivery","source":"amqp://broker:5672/queue",
+			"time":"2018-11-20T13:00:00Z","datacontenttype":"application/json","region":"emea","data":{"a":1}}`,
+	}, {
+		name:    "text data",
+		version: SpecVersion10,
+		ctx:     ctx("text/plain; charset=utf-8"),
+		data:    "hello",
+		want: `{"specversion":"1.0","id":"1","type":"amqp.message.delivery","source":"amqp://broker:5672/queue",
+			"time":"2018-11-20T13:00:00Z","datacontenttype":"text/plain; charset=utf-8","region":"emea","data":"hello"}`,
+	}, {
+		name:    "binary data",
+		version: SpecVersion10,
+		ctx:     ctx("application/octet-stream"),
+		data:    "\x00\xff",
+		want: `{"specversion":"1.0","id":"1","type":"amqp.message.delivery","source":"amqp://broker:5672/queue",
+			"time":"2018-11-20T13:00:00Z","datacontenttype":"application/octet-stream","region":"emea","data_base64":"AP8="}`,
+	}, {
+		name:    "0.1 binary data",
+		version: SpecVersion01,
+		ctx:     ctx("application/octet-stream"),
+		data:    "\x00\xff",
+		want: `{"cloudEventsVersion":"0.1","eventID":"1","eventType":"amqp.message.delivery","source":"amqp://broker:5672/queue",
+			"eventTime":"2018-11-20T13:00:00Z","contentType":"application/octet-stream","extensions":{"region":"emea"},"data":"AP8="}`,
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := newStructuredRequest("http://sink.example.com/", test.version, test.ctx, []byte(test.data))
+			if err != nil {
+				t.Fatalf("newStructuredRequest() error = %v", err)
+			}
+			if got, want := req.Header.Get("Content-Type"), "application/cloudevents+json; charset=utf-8"; got != want {
+				t.Errorf("Content-Type = %q, want %q", got, want)
+			}
+			body, _ := ioutil.ReadAll(req.Body)
+			var got, want interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("invalid body %s: %v", body, err)
+			}
+			if err := json.Unmarshal([]byte(test.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected envelope (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,281 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Files of the config secret holding the client certificate to
+// authenticate to the broker with.
+const (
+	clientCertFile       = "tls.crt"
+	clientKeyFile        = "tls.key"
+	clientPassphraseFile = "tls.key.passphrase"
+)
+
+// loadClientCert reads the client certificate and key from dir, or returns
+// nil if there is none.  The key may be an encrypted PEM block, decrypted
+// with the passphrase in tls.key.passphrase.
+func loadClientCert(dir string) (*tls.Certificate, error) {
+	read := func(name string) ([]byte, error) {
+		b, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return b, err
+	}
+
+	cert, err := read(clientCertFile)
+	if err != nil {
+		return nil, err
+	}
+	key, err := read(clientKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case len(cert) == 0 && len(key) == 0:
+		return nil, nil
+	case len(cert) == 0 || len(key) == 0:
+		return nil, fmt.Errorf("%s and %s must be provided together", clientCertFile, clientKeyFile)
+	}
+
+	passphrase, err := read(clientPassphraseFile)
+	if err != nil {
+		return nil, err
+	}
+	if key, err = decryptKey(key, bytes.TrimRight(passphrase, "\r\n")); err != nil {
+		return nil, err
+	}
+	pair, err := tls.X509KeyPair(cert, key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid client certificate: %s", err)
+	}
+	return &pair, nil
+}
+
+// decryptKey returns the PEM encoded key, decrypted with passphrase if it
+// is an encrypted PKCS#8 key or encrypted with legacy PEM encryption.
+func decryptKey(key, passphrase []byte) ([]byte, error) {
+	block, _ := pem.Decode(key)
+	if block == nil {
+		return key, nil
+	}
+	encrypted := block.Type == "ENCRYPTED PRIVATE KEY"
+	if !encrypted && !x509.IsEncryptedPEMBlock(block) {
+		return key, nil
+	}
+	if len(passphrase) == 0 {
+		return nil, fmt.Errorf("%s is encrypted and %s is missing", clientKeyFile, clientPassphraseFile)
+	}
+	if encrypted {
+		der, err := decryptPKCS8(block.Bytes, passphrase)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt %s: %s", clientKeyFile, err)
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	der, err := x509.DecryptPEMBlock(block, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("cannot decrypt %s: %s", clientKeyFile, err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: block.Type, Bytes: der}), nil
+}
+
+// TLS verification modes of the broker's certificate.
+const (
+	// Verify the certificate chain and that it names the broker.
+	VerifyFull = "full"
+	// Verify the certificate chain only, for brokers whose certificate
+	// names differ from the host the adapter connects to.
+	VerifyCAOnly = "ca-only"
+	// Do not verify the certificate.  For development only.
+	VerifyNone = "none"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// tlsVersion returns the TLS version named name.  Versions before 1.2 are
+// refused.
+func tlsVersion(name string) (uint16, error) {
+	switch name {
+	case "1.0", "1.1":
+		return 0, fmt.Errorf("TLS version %s is insecure, use 1.2 or 1.3", name)
+	}
+	v, ok := tlsVersions[name]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", name)
+	}
+	return v, nil
+}
+
+// TLSConfig is the "tls" section of connect-config.
+type TLSConfig struct {
+	// Name to verify the broker's certificate against and send in SNI,
+	// if different from the host connected to.
+	ServerName string `json:"serverName"`
+	// Range of TLS versions, "1.2" or "1.3", to negotiate.
+	MinVersion string `json:"minVersion"`
+	MaxVersion string `json:"maxVersion"`
+	// Cipher suites for TLS 1.2, by their Go names, e.g.
+	// "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256".  Empty uses Go's defaults.
+	// Go does not allow the TLS 1.3 suites to be configured.
+	CipherSuites []string `json:"cipherSuites"`
+	// How the broker's certificate is verified: VerifyFull (the default),
+	// VerifyCAOnly or VerifyNone.
+	Verify string `json:"verify"`
+	// Optional base64 SHA-256 digests of the SubjectPublicKeyInfo of the
+	// certificates the broker may present.  Any other is refused.
+	PinnedKeys []string `json:"pinnedKeys"`
+}
+
+// validate checks c by applying it to a scratch configuration.
+func (c *TLSConfig) validate() error {
+	return c.apply(&tls.Config{})
+}
+
+// apply sets the options of c on config, whose RootCAs must already be
+// set.
+func (c *TLSConfig) apply(config *tls.Config) error {
+	config.ServerName = c.ServerName
+	if c.MinVersion != "" {
+		v, err := tlsVersion(c.MinVersion)
+		if err != nil {
+			return err
+		}
+		config.MinVersion = v
+	}
+	if c.MaxVersion != "" {
+		v, err := tlsVersion(c.MaxVersion)
+		if err != nil {
+			return err
+		}
+		config.MaxVersion = v
+	}
+	if config.MinVersion != 0 && config.MaxVersion != 0 && config.MinVersion > config.MaxVersion {
+		return fmt.Errorf("TLS minVersion %s is above maxVersion %s", c.MinVersion, c.MaxVersion)
+	}
+	for _, name := range c.CipherSuites {
+		id, err := cipherSuite(name)
+		if err != nil {
+			return err
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+
+	var pins [][]byte
+	for _, p := range c.PinnedKeys {
+		b, err := base64.StdEncoding.DecodeString(p)
+		if err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("bad pinned key %q: want a base64 SHA-256 digest", p)
+		}
+		pins = append(pins, b)
+	}
+
+	var verifyChain bool
+	switch c.Verify {
+	case "", VerifyFull:
+	case VerifyCAOnly:
+		config.InsecureSkipVerify = true
+		verifyChain = true
+	case VerifyNone:
+		config.InsecureSkipVerify = true
+	default:
+		return fmt.Errorf("unknown TLS verify mode %q", c.Verify)
+	}
+	if !verifyChain && len(pins) == 0 {
+		return nil
+	}
+	roots := config.RootCAs
+	config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		return verifyPeer(rawCerts, roots, verifyChain, pins)
+	}
+	return nil
+}
+
+// verifyPeer checks the chain the broker presented against roots, if
+// verifyChain is set, and the leaf's public key against pins, if any.
+func verifyPeer(rawCerts [][]byte, roots *x509.CertPool, verifyChain bool, pins [][]byte) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("broker presented no certificate")
+	}
+	certs := make([]*x509.Certificate, len(rawCerts))
+	for i, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("bad broker certificate: %s", err)
+		}
+		certs[i] = cert
+	}
+	if verifyChain {
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+		}
+		for _, cert := range certs[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		if _, err := certs[0].Verify(opts); err != nil {
+			return err
+		}
+	}
+	if len(pins) == 0 {
+		return nil
+	}
+	digest := sha256.Sum256(certs[0].RawSubjectPublicKeyInfo)
+	for _, pin := range pins {
+		if bytes.Equal(pin, digest[:]) {
+			return nil
+		}
+	}
+	return fmt.Errorf("broker certificate public key is not pinned")
+}
+
+// cipherSuite returns the ID of the TLS 1.2 cipher suite named name.
+// Insecure suites and TLS 1.3 suites, which Go ignores, are refused.
+func cipherSuite(name string) (uint16, error) {
+	for _, s := range tls.InsecureCipherSuites() {
+		if s.Name == name {
+			return 0, fmt.Errorf("TLS cipher suite %q is insecure", name)
+		}
+	}
+	for _, s := range tls.CipherSuites() {
+		if s.Name != name {
+			continue
+		}
+		for _, v := range s.SupportedVersions {
+			if v == tls.VersionTLS12 {
+				return s.ID, nil
+			}
+		}
+		return 0, fmt.Errorf("TLS cipher suite %q is for TLS 1.3, whose suites cannot be configured", name)
+	}
+	return 0, fmt.Errorf("unknown TLS cipher suite %q", name)
+}
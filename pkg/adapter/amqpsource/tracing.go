@@ -0,0 +1,204 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/knative/pkg/cloudevents"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"go.uber.org/zap"
+	"qpid.apache.org/amqp"
+)
+
+// Trace context is propagated in the W3C Trace Context format: the
+// traceparent and tracestate headers, which the CloudEvents distributed
+// tracing extension uses too.
+var propagator = propagation.TraceContext{}
+
+const tracerName = "knative.dev/eventing-sources/amqpsource"
+
+// setupTracing creates the tracer that exports spans over OTLP/HTTP to
+// TracingEndpoint, e.g. "http://otel-collector:4318".  Without an endpoint
+// spans are not recorded, but trace context is still propagated from AMQP
+// messages to the sink.
+func (a *Adapter) setupTracing() error {
+	if a.TracingEndpoint == "" {
+		a.tracer = noop.NewTracerProvider().Tracer(tracerName)
+		return nil
+	}
+	u, err := parseURL(a.TracingEndpoint)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("bad tracing endpoint %q", RedactURL(a.TracingEndpoint))
+	}
+	opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(u.Host)}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	if u.Path != "" && u.Path != "/" {
+		opts = append(opts, otlptracehttp.WithURLPath(u.Path))
+	}
+	exporter, err := otlptracehttp.New(context.Background(), opts...)
+	if err != nil {
+		return err
+	}
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "amqpsource-adapter"),
+		attribute.String("k8s.namespace.name", a.SourceNamespace),
+		attribute.String("knative.source.name", a.SourceName),
+	)
+	a.tracerProvider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.AlwaysSample())),
+	)
+	a.tracer = a.tracerProvider.Tracer(tracerName)
+	a.logger().Infow("Exporting traces", "endpoint", RedactURL(a.TracingEndpoint))
+	return nil
+}
+
+// shutdownTracing flushes spans not yet exported.
+func (a *Adapter) shutdownTracing() {
+	if a.tracerProvider == nil {
+		return
+	}
+	if err := a.tracerProvider.Shutdown(context.Background()); err != nil {
+		a.logger().Errorw("Failed to export traces", zap.Error(err))
+	}
+}
+
+// getTracer returns the adapter's tracer, which does not record spans if
+// tracing was not set up.
+func (a *Adapter) getTracer() trace.Tracer {
+	if a.tracer == nil {
+		return noop.NewTracerProvider().Tracer(tracerName)
+	}
+	return a.tracer
+}
+
+// messageCarrier reads trace context from the application properties of
+// an AMQP message: plain traceparent and tracestate properties, or the
+// CloudEvents distributed tracing extension of a binary mode event.
+type messageCarrier map[string]interface{}
+
+func (c messageCarrier) Get(key string) string {
+	for _, k := range []string{key, cePropPrefix + key, ceLegacyPropPrefix + key} {
+		if s, ok := c[k].(string); ok {
+			return s
+		}
+	}
+	return ""
+}
+
+// Set is not used: trace context is only extracted from messages.
+func (c messageCarrier) Set(key, value string) {}
+
+func (c messageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// startReceive starts the span for delivering m to the sink, continuing
+// the trace m carries, if any.  The returned context is cancelled when the
+// shutdown grace period expires.
+func (a *Adapter) startReceive(m amqp.Message) (context.Context, trace.Span) {
+	parent := propagator.Extract(a.deliveryContext(), messageCarrier(m.ApplicationProperties()))
+	return a.getTracer().Start(parent, "amqp.receive",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "amqp"),
+			attribute.String("messaging.source.name", a.SpecSource),
+			attribute.String("messaging.message.id", idString(m.MessageId())),
+		))
+}
+
+// startReceiveBatch starts the span for delivering a batch of messages,
+// linked to the trace of each message that carries one.
+func (a *Adapter) startReceiveBatch(ms []amqp.Message) (context.Context, trace.Span) {
+	var links []trace.Link
+	for _, m := range ms {
+		ctx := propagator.Extract(context.Background(), messageCarrier(m.ApplicationProperties()))
+		if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	return a.getTracer().Start(a.deliveryContext(), "amqp.receive batch",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("messaging.system", "amqp"),
+			attribute.String("messaging.source.name", a.SpecSource),
+			attribute.Int("messaging.batch.message_count", len(ms)),
+		))
+}
+
+// startSinkRequest starts the span for a request to the sink.
+func (a *Adapter) startSinkRequest(parent context.Context) (context.Context, trace.Span) {
+	return a.getTracer().Start(parent, "POST sink", trace.WithSpanKind(trace.SpanKindClient))
+}
+
+// injectTraceContext describes req in span, without any password in its
+// URL, and injects the trace context of ctx into its headers.
+func injectTraceContext(ctx context.Context, span trace.Span, req *http.Request) {
+	span.SetAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", redactedURL(req.URL)),
+	)
+	propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+}
+
+// eventTraceContext returns ectx with its distributed tracing extension, if
+// it has one, set to the trace context of ctx, so that it names the same
+// parent span as the traceparent header of the request to the sink.
+func eventTraceContext(ctx context.Context, ectx cloudevents.EventContext) cloudevents.EventContext {
+	if _, ok := ectx.Extensions["traceparent"]; !ok {
+		return ectx
+	}
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	ext := make(map[string]interface{}, len(ectx.Extensions))
+	for k, v := range ectx.Extensions {
+		ext[k] = v
+	}
+	delete(ext, "tracestate")
+	for k, v := range carrier {
+		ext[k] = v
+	}
+	ectx.Extensions = ext
+	return ectx
+}
+
+// endSpan records the outcome of an operation and ends its span.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
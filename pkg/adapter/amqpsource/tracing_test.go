@@ -0,0 +1,156 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/pkg/cloudevents"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"qpid.apache.org/amqp"
+)
+
+const incomingTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestTracePropagation(t *testing.T) {
+	tests := []struct {
+		name  string
+		props map[string]interface{}
+	}{{
+		name:  "traceparent property",
+		props: map[string]interface{}{"traceparent": incomingTraceparent},
+	}, {
+		name:  "CloudEvents distributed tracing extension",
+		props: map[string]interface{}{"cloudEvents_traceparent": incomingTraceparent},
+	}}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var exports int
+			collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/v1/traces" {
+					mu.Lock()
+					exports++
+					mu.Unlock()
+				}
+			}))
+			defer collector.Close()
+
+			var got, gotExt string
+			sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r.Header.Get("traceparent")
+				gotExt = r.Header.Get("ce-traceparent")
+				w.WriteHeader(http.StatusAccepted)
+			}))
+			defer sink.Close()
+
+			a := &Adapter{SinkURI: sink.URL, TracingEndpoint: collector.URL}
+			if err := a.setupTracing(); err != nil {
+				t.Fatalf("setupTracing() error = %v", err)
+			}
+			m := amqp.NewMessage()
+			m.SetApplicationProperties(test.props)
+			parent, span := a.startReceive(m)
+			ctx := cloudevents.EventContext{
+				EventID:    "1",
+				EventType:  "t",
+				Source:     "s",
+				Extensions: map[string]interface{}{"traceparent": incomingTraceparent},
+			}
+			err := a.postEvent(parent, ctx, []byte("hello"))
+			endSpan(span, err)
+			if err != nil {
+				t.Fatalf("postEvent() error = %v", err)
+			}
+			a.shutdownTracing()
+
+			// Same trace, new parent span: the sink request's.
+			parts := strings.Split(got, "-")
+			if len(parts) != 4 || parts[1] != "4bf92f3577b34da6a3ce929d0e0e4736" || parts[2] == "00f067aa0ba902b7" {
+				t.Errorf("sink traceparent = %q, want a child of %q", got, incomingTraceparent)
+			}
+			// The tracing extension names the same parent.
+			if gotExt != got {
+				t.Errorf("sink ce-traceparent = %q, want %q", gotExt, got)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if exports == 0 {
+				t.Error("no spans exported to the collector")
+			}
+		})
+	}
+}
+
+func TestTracePropagationWithoutExporter(t *testing.T) {
+	var got string
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	a := &Adapter{SinkURI: sink.URL}
+	m := amqp.NewMessage()
+	m.SetApplicationProperties(map[string]interface{}{"traceparent": incomingTraceparent})
+	parent, span := a.startReceive(m)
+	defer span.End()
+	ctx := cloudevents.EventContext{EventID: "1", EventType: "t", Source: "s"}
+	if err := a.postEvent(parent, ctx, []byte("hello")); err != nil {
+		t.Fatalf("postEvent() error = %v", err)
+	}
+	// Spans are not recorded, so the incoming context passes through.
+	if got != incomingTraceparent {
+		t.Errorf("sink traceparent = %q, want %q", got, incomingTraceparent)
+	}
+}
+
+func TestSinkSpanRedactsURL(t *testing.T) {
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer sink.Close()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	sinkURI := strings.Replace(sink.URL, "http://", "http://user:secret@", 1)
+	a := &Adapter{SinkURI: sinkURI, tracer: provider.Tracer(tracerName)}
+	ctx := cloudevents.EventContext{EventID: "1", EventType: "t", Source: "s"}
+	if err := a.postEvent(context.Background(), ctx, []byte("hello")); err != nil {
+		t.Fatalf("postEvent() error = %v", err)
+	}
+
+	var got []string
+	for _, span := range recorder.Ended() {
+		for _, kv := range span.Attributes() {
+			if kv.Key == "url.full" {
+				got = append(got, kv.Value.AsString())
+			}
+		}
+	}
+	if want := []string{RedactURL(sinkURI)}; !cmp.Equal(want, got) {
+		t.Errorf("url.full = %q, want %q", got, want)
+	}
+}
@@ -53,6 +53,89 @@ type AmqpSourceSpec struct {
 	// +optional
 	Credit int `json:"credit"`
 
+	// DeliveryMode selects how messages are delivered to the sink:
+	// "ordered" (the default) delivers one message at a time in the order
+	// received; "concurrent" delivers up to Credit messages at once, each
+	// settled as soon as its delivery completes; "grouped" delivers the
+	// messages of each group in order, and different groups concurrently.
+	// +optional
+	DeliveryMode string `json:"deliveryMode,omitempty"`
+
+	// GroupKey names the application property that identifies the group
+	// of a message in "grouped" delivery mode.  Default: the AMQP group-id.
+	// Messages without a group are delivered concurrently.
+	// +optional
+	GroupKey string `json:"groupKey,omitempty"`
+
+	// MaxConcurrentGroups limits the number of groups delivered at once
+	// in "grouped" delivery mode.  Default and maximum = Credit.
+	// +optional
+	MaxConcurrentGroups int `json:"maxConcurrentGroups,omitempty"`
+
+	// Batch configures posting the events of several messages to the
+	// sink as one CloudEvents batch.  By default each event is posted on
+	// its own.
+	// +optional
+	Batch *AmqpBatchSpec `json:"batch,omitempty"`
+
+	// ApplicationProperties selects which AMQP application properties are
+	// mapped to CloudEvent extension attributes.  By default all of them
+	// are mapped.
+	// +optional
+	ApplicationProperties *AmqpPropertyFilter `json:"applicationProperties,omitempty"`
+
+	// DeliveryFailure selects how AMQP deliveries are settled when their
+	// event is not accepted by the sink.  Events accepted with a 2xx status
+	// are always settled as accepted.  DeadLetter takes precedence: with a
+	// dead letter address, failed messages are sent there instead.
+	// +optional
+	DeliveryFailure *AmqpDeliveryFailurePolicy `json:"deliveryFailure,omitempty"`
+
+	// Retry configures how delivery of an event to the sink is retried
+	// before the AMQP delivery is settled as a failure.  Only transient
+	// failures (see DeliveryFailure) are retried.
+	// +optional
+	Retry *AmqpRetrySpec `json:"retry,omitempty"`
+
+	// DeadLetter configures where messages are sent when the sink does not
+	// accept their event, either permanently or after all retries.  The
+	// original delivery is accepted once the dead letter node accepts the
+	// message, and released if it does not.
+	// +optional
+	DeadLetter *AmqpDeadLetterSpec `json:"deadLetter,omitempty"`
+
+	// GracePeriod is the time allowed on shutdown, e.g. during a rolling
+	// update, for in-flight deliveries to the sink to complete before
+	// they are abandoned and their messages released.  A Go duration
+	// string.  Default = "20s".
+	// +optional
+	GracePeriod string `json:"gracePeriod,omitempty"`
+
+	// CloudEventsSpecVersion is the version of the CloudEvents HTTP
+	// binding used to send events to the sink: "1.0" (the default) or
+	// "0.1" for legacy consumers.
+	// +optional
+	CloudEventsSpecVersion string `json:"cloudEventsSpecVersion,omitempty"`
+
+	// ContentMode is the CloudEvents HTTP content mode used to send
+	// events to the sink: "binary" (the default) sends the attributes as
+	// headers and the data as the body; "structured" sends an
+	// application/cloudevents+json envelope, for sinks that only read the
+	// body.
+	// +optional
+	ContentMode string `json:"contentMode,omitempty"`
+
+	// SinkClient configures the HTTP client used to deliver events to the
+	// sink.
+	// +optional
+	SinkClient *AmqpSinkClientSpec `json:"sinkClient,omitempty"`
+
+	// Tracing configures the export of trace spans.  Trace context in
+	// AMQP messages is propagated to the sink whether or not spans are
+	// exported.
+	// +optional
+	Tracing *AmqpTracingSpec `json:"tracing,omitempty"`
+
 	// ServiceAccountName is the name of the ServiceAccount to use to run this
 	// source.
 	// +optional
@@ -64,6 +147,131 @@ type AmqpSourceSpec struct {
 	Sink *corev1.ObjectReference `json:"sink,omitempty"`
 }
 
+// AmqpPropertyFilter is an allow/deny list of AMQP application property
+// names.
+type AmqpPropertyFilter struct {
+	// Allow lists the property names to map.  If empty, all properties not
+	// in Deny are mapped.
+	// +optional
+	Allow []string `json:"allow,omitempty"`
+
+	// Deny lists the property names never to map.  Deny takes precedence
+	// over Allow.
+	// +optional
+	Deny []string `json:"deny,omitempty"`
+}
+
+// AmqpBatchSpec configures batched delivery.  A batch is posted once it
+// holds MaxSize messages or its oldest message has waited for Window.
+type AmqpBatchSpec struct {
+	// MaxSize is the maximum number of messages in a batch.  Values <= 1
+	// disable batching.
+	MaxSize int `json:"maxSize"`
+
+	// Window is the longest time a message waits for its batch to fill
+	// up.  A Go duration string.  Default = "100ms".
+	// +optional
+	Window string `json:"window,omitempty"`
+}
+
+// AmqpDeliveryFailurePolicy maps delivery failures to AMQP outcomes.  Each
+// outcome is one of "accept", "reject" or "release".  It is not used when
+// the source has a DeadLetter address.
+type AmqpDeliveryFailurePolicy struct {
+	// Permanent is the outcome for messages the sink refuses with a 4xx
+	// status (except 408 and 429), or that cannot be converted to an event.
+	// Default = "reject".
+	// +optional
+	Permanent string `json:"permanent,omitempty"`
+
+	// Transient is the outcome for messages the sink fails with a 5xx, 408
+	// or 429 status, or does not respond to in time.  Default = "release".
+	// +optional
+	Transient string `json:"transient,omitempty"`
+}
+
+// AmqpRetrySpec is a retry policy with exponential backoff.  Durations are
+// Go duration strings, e.g. "250ms" or "1m".
+type AmqpRetrySpec struct {
+	// MaxAttempts is the maximum number of delivery attempts, including
+	// the first.  Default = 5.  1 disables retries.
+	// +optional
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+
+	// InitialBackoff is the delay before the first retry.  It doubles
+	// for each further retry.  Default = "100ms".
+	// +optional
+	InitialBackoff string `json:"initialBackoff,omitempty"`
+
+	// MaxBackoff caps the delay between retries.  Default = "10s".
+	// +optional
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+
+	// JitterPercent is the percentage of each delay that is randomized.
+	// Default = 20.  Legal values: 0-100; 0 disables jitter.
+	// +optional
+	JitterPercent *int `json:"jitterPercent,omitempty"`
+
+	// AttemptTimeout limits the duration of each delivery attempt.
+	// Default = "30s".
+	// +optional
+	AttemptTimeout string `json:"attemptTimeout,omitempty"`
+}
+
+// AmqpDeadLetterSpec names a dead letter node.
+type AmqpDeadLetterSpec struct {
+	// AMQP address of the dead letter node.  A plain address uses the
+	// source's connection.  An AMQP URI with a host uses a separate
+	// connection, with ConfigSecret providing the connection defaults.
+	// Examples:
+	//  myqueue.dlq
+	//  amqps://host:port/myqueue.dlq
+	Address string `json:"address"`
+}
+
+// AmqpSinkClientSpec configures the HTTP client shared by all deliveries
+// to the sink.  Durations are Go duration strings.
+type AmqpSinkClientSpec struct {
+	// Timeout limits each request to the sink, including reading the
+	// response.  Default: only Retry.AttemptTimeout applies.
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+
+	// MaxIdleConns is the maximum number of idle keep-alive connections
+	// kept open.  Default = 100.
+	// +optional
+	MaxIdleConns int `json:"maxIdleConns,omitempty"`
+
+	// MaxIdleConnsPerHost is the maximum number of idle keep-alive
+	// connections kept open to each sink host.  Default = Credit.
+	// +optional
+	MaxIdleConnsPerHost int `json:"maxIdleConnsPerHost,omitempty"`
+
+	// IdleConnTimeout is how long an idle connection is kept open.
+	// Default = "90s".
+	// +optional
+	IdleConnTimeout string `json:"idleConnTimeout,omitempty"`
+
+	// DisableHTTP2 makes the adapter use HTTP/1.1 even if an https sink
+	// supports HTTP/2.
+	// +optional
+	DisableHTTP2 bool `json:"disableHTTP2,omitempty"`
+
+	// TLSSecret names a secret with TLS settings for https sinks, all
+	// optional: ca.crt, the CA certificate(s) to verify the sink with
+	// instead of the system roots, and tls.crt and tls.key, a client
+	// certificate and private key in PEM format.
+	// +optional
+	TLSSecret *corev1.LocalObjectReference `json:"tlsSecret,omitempty"`
+}
+
+// AmqpTracingSpec configures trace span export.
+type AmqpTracingSpec struct {
+	// Endpoint is the URL of an OpenTelemetry collector receiving OTLP
+	// over HTTP, e.g. http://otel-collector.observability:4318.
+	Endpoint string `json:"endpoint"`
+}
+
 const (
 	// AmqpSourceConditionReady has status True when the
 	// source is ready to send events.
@@ -76,9 +284,14 @@ const (
 	// AmqpSourceConditionDeployed has status True when the
 	// AmqpSource has had it's receive adapter deployment created.
 	AmqpSourceConditionDeployed duckv1alpha1.ConditionType = "Deployed"
+
+	// AmqpSourceConditionSpecValid has status True when the AmqpSource
+	// spec holds values the receive adapter can run with.
+	AmqpSourceConditionSpecValid duckv1alpha1.ConditionType = "SpecValid"
 )
 
 var amqpSourceCondSet = duckv1alpha1.NewLivingConditionSet(
+	AmqpSourceConditionSpecValid,
 	AmqpSourceConditionSinkProvided,
 	AmqpSourceConditionDeployed)
 
@@ -126,6 +339,17 @@ func (s *AmqpSourceStatus) MarkNoSink(reason, messageFormat string, messageA ...
 	condSet.Manage(s).MarkFalse(AmqpSourceConditionSinkProvided, reason, messageFormat, messageA...)
 }
 
+// MarkSpecValid sets the condition that the source spec is valid.
+func (s *AmqpSourceStatus) MarkSpecValid() {
+	amqpSourceCondSet.Manage(s).MarkTrue(AmqpSourceConditionSpecValid)
+}
+
+// MarkSpecInvalid sets the condition that the source spec holds a value the
+// receive adapter cannot run with.
+func (s *AmqpSourceStatus) MarkSpecInvalid(reason, messageFormat string, messageA ...interface{}) {
+	amqpSourceCondSet.Manage(s).MarkFalse(AmqpSourceConditionSpecValid, reason, messageFormat, messageA...)
+}
+
 // MarkDeployed sets the condition that the source has been deployed.
 func (s *AmqpSourceStatus) MarkDeployed() {
 	condSet.Manage(s).MarkTrue(AmqpSourceConditionDeployed)
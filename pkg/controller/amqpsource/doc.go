@@ -19,4 +19,5 @@ limitations under the License.
 // +kubebuilder:rbac:groups=sources.eventing.knative.dev,resources=amqpsources,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=sources.eventing.knative.dev,resources=amqpsources/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=eventing.knative.dev,resources=channels,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
 package amqpsource
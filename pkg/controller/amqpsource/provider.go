@@ -40,8 +40,26 @@ const (
 	// raImageEnvVar is the name of the environment variable that contains the receive adapter's
 	// image. It must be defined.
 	raImageEnvVar = "AMQP_RA_IMAGE"
+
+	// systemNamespaceEnvVar is the name of the environment variable that
+	// contains the controller's namespace, which holds config-logging.
+	systemNamespaceEnvVar  = "SYSTEM_NAMESPACE"
+	defaultSystemNamespace = "knative-sources"
+
+	// The receive adapter's logging is configured by these keys of the
+	// config-logging ConfigMap.
+	loggingConfigMapName = "config-logging"
+	loggingConfigKey     = "zap-logger-config"
+	loggingLevelKey      = "loglevel.amqpsource"
 )
 
+func systemNamespace() string {
+	if ns := os.Getenv(systemNamespaceEnvVar); ns != "" {
+		return ns
+	}
+	return defaultSystemNamespace
+}
+
 // Add creates a new AmqpSource Controller and adds it to the Manager with
 // default RBAC. The Manager will set fields on the Controller and Start it when
 // the Manager is Started.
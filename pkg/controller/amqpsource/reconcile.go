@@ -71,6 +71,14 @@ func (r *reconciler) Reconcile(ctx context.Context, object runtime.Object) (runt
 
 	source.Status.InitializeConditions()
 
+	if err := validateSpec(&source.Spec); err != nil {
+		// Nothing to retry until the spec is fixed.
+		source.Status.MarkSpecInvalid("InvalidSpec", "%v", err)
+		r.recorder.Eventf(source, corev1.EventTypeWarning, "InvalidSpec", "Invalid spec: %v", err)
+		return source, nil
+	}
+	source.Status.MarkSpecValid()
+
 	sinkURI, err := sinks.GetSinkURI(ctx, r.client, source.Spec.Sink, source.Namespace)
 	if err != nil {
 		source.Status.MarkNoSink("NotFound", "")
@@ -85,6 +93,9 @@ func (r *reconciler) Reconcile(ctx context.Context, object runtime.Object) (runt
 		SinkURI: sinkURI,
 		Address: source.Spec.Address,
 	}
+	if err := r.getLoggingConfig(ctx, args); err != nil {
+		return object, err
+	}
 
 	deploy, err := r.getDeployment(ctx, source)
 	if err != nil {
@@ -175,6 +186,22 @@ func (r *reconciler) createDeployment(ctx context.Context, source *v1alpha1.Amqp
 	return deployment, nil
 }
 
+// getLoggingConfig sets the adapter's logging configuration from the
+// config-logging ConfigMap in the system namespace, if there is one.
+func (r *reconciler) getLoggingConfig(ctx context.Context, args *resources.AdapterArguments) error {
+	cm := &corev1.ConfigMap{}
+	key := client.ObjectKey{Namespace: systemNamespace(), Name: loggingConfigMapName}
+	if err := r.client.Get(ctx, key, cm); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+	args.LoggingConfig = cm.Data[loggingConfigKey]
+	args.LoggingLevel = cm.Data[loggingLevelKey]
+	return nil
+}
+
 func (r *reconciler) InjectClient(c client.Client) error {
 	r.client = c
 	return nil
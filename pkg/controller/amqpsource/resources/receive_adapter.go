@@ -17,13 +17,16 @@ limitations under the License.
 package resources
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
+	"time"
 
 	"github.com/knative/eventing-sources/pkg/apis/sources/v1alpha1"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
 type AdapterArguments struct {
@@ -32,6 +35,10 @@ type AdapterArguments struct {
 	Labels  map[string]string
 	SinkURI string
 	Address string
+	// Zap configuration and adapter log level from the config-logging
+	// ConfigMap.  Empty uses the adapter's defaults.
+	LoggingConfig string
+	LoggingLevel  string
 	// TODO: full connect-config opts
 }
 
@@ -39,6 +46,20 @@ const (
 	credsVolume    = "amqp-config"
 	credsMountPath = "/var/secrets/amqp"
 	defaultCredit = 10
+	// Seconds added to the adapter's grace period for the pod's.
+	terminationGraceMargin = 10
+)
+
+const (
+	sinkTLSVolume    = "sink-tls"
+	sinkTLSMountPath = "/var/secrets/sink-tls"
+)
+
+const (
+	// The adapter serves Prometheus metrics on this port at /metrics.
+	metricsPort = 9090
+	// The adapter serves its /healthz and /readyz probes on this port.
+	healthPort = 8080
 )
 
 
@@ -65,6 +86,9 @@ func MakeDeployment(org *appsv1.Deployment, args *AdapterArguments) *appsv1.Depl
 				ObjectMeta: metav1.ObjectMeta{
 					Annotations: map[string]string{
 						"sidecar.istio.io/inject": "true",
+						"prometheus.io/scrape":    "true",
+						"prometheus.io/port":      strconv.Itoa(metricsPort),
+						"prometheus.io/path":      "/metrics",
 					},
 					Labels: args.Labels,
 				},
@@ -87,6 +111,54 @@ func MakeDeployment(org *appsv1.Deployment, args *AdapterArguments) *appsv1.Depl
 									Name:  "AMQP_CREDIT",
 									Value: strconv.Itoa(credit),
 								},
+								{
+									Name:  "SOURCE_NAME",
+									Value: args.Source.Name,
+								},
+								{
+									Name:  "SOURCE_NAMESPACE",
+									Value: args.Source.Namespace,
+								},
+								{
+									Name:  "METRICS_PORT",
+									Value: strconv.Itoa(metricsPort),
+								},
+								{
+									Name:  "HEALTH_PORT",
+									Value: strconv.Itoa(healthPort),
+								},
+							},
+							Ports: []corev1.ContainerPort{
+								{
+									Name:          "metrics",
+									ContainerPort: metricsPort,
+								},
+								{
+									Name:          "health",
+									ContainerPort: healthPort,
+								},
+							},
+							// Ready only while the receiver link is attached, so the
+							// Deployed condition means the source is consuming messages.
+							LivenessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/healthz",
+										Port: intstr.FromInt(healthPort),
+									},
+								},
+								InitialDelaySeconds: 5,
+								PeriodSeconds:       10,
+							},
+							ReadinessProbe: &corev1.Probe{
+								Handler: corev1.Handler{
+									HTTPGet: &corev1.HTTPGetAction{
+										Path: "/readyz",
+										Port: intstr.FromInt(healthPort),
+									},
+								},
+								PeriodSeconds:    5,
+								FailureThreshold: 2,
 							},
 							ImagePullPolicy: corev1.PullIfNotPresent,
 						},
@@ -96,6 +168,136 @@ func MakeDeployment(org *appsv1.Deployment, args *AdapterArguments) *appsv1.Depl
 		},
 	}
 
+	if mode := args.Source.Spec.DeliveryMode; mode != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "AMQP_DELIVERY_MODE",
+			Value: mode,
+		})
+	}
+	if version := args.Source.Spec.CloudEventsSpecVersion; version != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "CE_SPEC_VERSION",
+			Value: version,
+		})
+	}
+	if mode := args.Source.Spec.ContentMode; mode != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "CE_CONTENT_MODE",
+			Value: mode,
+		})
+	}
+	if key := args.Source.Spec.GroupKey; key != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "AMQP_GROUP_KEY",
+			Value: key,
+		})
+	}
+	if maxGroups := args.Source.Spec.MaxConcurrentGroups; maxGroups > 0 {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "AMQP_MAX_GROUPS",
+			Value: strconv.Itoa(maxGroups),
+		})
+	}
+
+	if batch := args.Source.Spec.Batch; batch != nil && batch.MaxSize > 1 {
+		env := &deploy.Spec.Template.Spec.Containers[0].Env
+		*env = append(*env, corev1.EnvVar{
+			Name:  "AMQP_BATCH_SIZE",
+			Value: strconv.Itoa(batch.MaxSize),
+		})
+		if batch.Window != "" {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_BATCH_WINDOW",
+				Value: batch.Window,
+			})
+		}
+	}
+
+	if filter := args.Source.Spec.ApplicationProperties; filter != nil {
+		env := &deploy.Spec.Template.Spec.Containers[0].Env
+		if len(filter.Allow) > 0 {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_PROPERTIES_ALLOW",
+				Value: jsonList(filter.Allow),
+			})
+		}
+		if len(filter.Deny) > 0 {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_PROPERTIES_DENY",
+				Value: jsonList(filter.Deny),
+			})
+		}
+	}
+
+	if policy := args.Source.Spec.DeliveryFailure; policy != nil {
+		env := &deploy.Spec.Template.Spec.Containers[0].Env
+		if policy.Permanent != "" {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_PERMANENT_FAILURE",
+				Value: policy.Permanent,
+			})
+		}
+		if policy.Transient != "" {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_TRANSIENT_FAILURE",
+				Value: policy.Transient,
+			})
+		}
+	}
+
+	if retry := args.Source.Spec.Retry; retry != nil {
+		env := &deploy.Spec.Template.Spec.Containers[0].Env
+		if retry.MaxAttempts > 0 {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_RETRY_MAX_ATTEMPTS",
+				Value: strconv.Itoa(retry.MaxAttempts),
+			})
+		}
+		if retry.InitialBackoff != "" {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_RETRY_INITIAL_BACKOFF",
+				Value: retry.InitialBackoff,
+			})
+		}
+		if retry.MaxBackoff != "" {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_RETRY_MAX_BACKOFF",
+				Value: retry.MaxBackoff,
+			})
+		}
+		if retry.JitterPercent != nil {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_RETRY_JITTER_PERCENT",
+				Value: strconv.Itoa(*retry.JitterPercent),
+			})
+		}
+		if retry.AttemptTimeout != "" {
+			*env = append(*env, corev1.EnvVar{
+				Name:  "AMQP_RETRY_ATTEMPT_TIMEOUT",
+				Value: retry.AttemptTimeout,
+			})
+		}
+	}
+
+	if dl := args.Source.Spec.DeadLetter; dl != nil && dl.Address != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "AMQP_DEAD_LETTER_URI",
+			Value: dl.Address,
+		})
+	}
+
+	if grace := args.Source.Spec.GracePeriod; grace != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "AMQP_GRACE_PERIOD",
+			Value: grace,
+		})
+		// Leave the adapter time to close its connection before the kubelet kills it.
+		if d, err := time.ParseDuration(grace); err == nil {
+			seconds := int64(d/time.Second) + terminationGraceMargin
+			deploy.Spec.Template.Spec.TerminationGracePeriodSeconds = &seconds
+		}
+	}
+
 	secretName := args.Source.Spec.ConfigSecret.Name
 	if secretName != "" {
 		mounts := []corev1.VolumeMount{  { Name:      credsVolume, MountPath: credsMountPath } }
@@ -119,5 +321,93 @@ func MakeDeployment(org *appsv1.Deployment, args *AdapterArguments) *appsv1.Depl
 		}
 		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, secretEnv)
 	}
+
+	if args.LoggingConfig != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "K_LOGGING_CONFIG",
+			Value: args.LoggingConfig,
+		})
+	}
+	if args.LoggingLevel != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "K_LOGGING_LEVEL",
+			Value: args.LoggingLevel,
+		})
+	}
+
+	if tracing := args.Source.Spec.Tracing; tracing != nil && tracing.Endpoint != "" {
+		deploy.Spec.Template.Spec.Containers[0].Env = append(deploy.Spec.Template.Spec.Containers[0].Env, corev1.EnvVar{
+			Name:  "TRACING_ENDPOINT",
+			Value: tracing.Endpoint,
+		})
+	}
+
+	if client := args.Source.Spec.SinkClient; client != nil {
+		addSinkClientEnv(deploy, client)
+	}
 	return deploy
 }
+
+// addSinkClientEnv passes the sink HTTP client settings to the adapter, and
+// mounts the client's TLS secret.
+func addSinkClientEnv(deploy *appsv1.Deployment, client *v1alpha1.AmqpSinkClientSpec) {
+	podSpec := &deploy.Spec.Template.Spec
+	env := &podSpec.Containers[0].Env
+	if client.Timeout != "" {
+		*env = append(*env, corev1.EnvVar{
+			Name:  "SINK_TIMEOUT",
+			Value: client.Timeout,
+		})
+	}
+	if client.MaxIdleConns > 0 {
+		*env = append(*env, corev1.EnvVar{
+			Name:  "SINK_MAX_IDLE_CONNS",
+			Value: strconv.Itoa(client.MaxIdleConns),
+		})
+	}
+	if client.MaxIdleConnsPerHost > 0 {
+		*env = append(*env, corev1.EnvVar{
+			Name:  "SINK_MAX_IDLE_CONNS_PER_HOST",
+			Value: strconv.Itoa(client.MaxIdleConnsPerHost),
+		})
+	}
+	if client.IdleConnTimeout != "" {
+		*env = append(*env, corev1.EnvVar{
+			Name:  "SINK_IDLE_CONN_TIMEOUT",
+			Value: client.IdleConnTimeout,
+		})
+	}
+	if client.DisableHTTP2 {
+		*env = append(*env, corev1.EnvVar{
+			Name:  "SINK_DISABLE_HTTP2",
+			Value: "true",
+		})
+	}
+	if client.TLSSecret != nil && client.TLSSecret.Name != "" {
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      sinkTLSVolume,
+			MountPath: sinkTLSMountPath,
+			ReadOnly:  true,
+		})
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: sinkTLSVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{
+					SecretName: client.TLSSecret.Name,
+				},
+			},
+		})
+		*env = append(*env, corev1.EnvVar{
+			Name:  "SINK_TLS_PATH",
+			Value: sinkTLSMountPath,
+		})
+	}
+}
+
+// jsonList encodes names as a JSON array, so names holding commas survive
+// the trip through the environment.
+func jsonList(names []string) string {
+	// Marshaling a []string cannot fail.
+	b, _ := json.Marshal(names)
+	return string(b)
+}
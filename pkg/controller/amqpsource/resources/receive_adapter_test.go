@@ -0,0 +1,81 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/knative/eventing-sources/pkg/apis/sources/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMakeDeploymentRetryEnv(t *testing.T) {
+	zero := 0
+	tests := []struct {
+		name  string
+		retry *v1alpha1.AmqpRetrySpec
+		want  map[string]string
+	}{{
+		name: "no retry policy",
+		want: map[string]string{},
+	}, {
+		name:  "defaults",
+		retry: &v1alpha1.AmqpRetrySpec{},
+		want:  map[string]string{},
+	}, {
+		name: "all fields",
+		retry: &v1alpha1.AmqpRetrySpec{
+			MaxAttempts:    3,
+			InitialBackoff: "250ms",
+			MaxBackoff:     "1m",
+			JitterPercent:  &zero,
+			AttemptTimeout: "5s",
+		},
+		want: map[string]string{
+			"AMQP_RETRY_MAX_ATTEMPTS":    "3",
+			"AMQP_RETRY_INITIAL_BACKOFF": "250ms",
+			"AMQP_RETRY_MAX_BACKOFF":     "1m",
+			"AMQP_RETRY_JITTER_PERCENT":  "0",
+			"AMQP_RETRY_ATTEMPT_TIMEOUT": "5s",
+		},
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			src := &v1alpha1.AmqpSource{
+				ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "default"},
+				Spec:       v1alpha1.AmqpSourceSpec{Retry: test.retry},
+			}
+			deploy := MakeDeployment(nil, &AdapterArguments{
+				Image:   "amqp-adapter",
+				Source:  src,
+				SinkURI: "http://sink.default.svc.cluster.local/",
+				Address: "orders",
+			})
+			got := map[string]string{}
+			for _, e := range deploy.Spec.Template.Spec.Containers[0].Env {
+				if strings.HasPrefix(e.Name, "AMQP_RETRY_") {
+					got[e.Name] = e.Value
+				}
+			}
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("unexpected retry env (-want, +got) = %v", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,61 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/knative/eventing-sources/pkg/apis/sources/v1alpha1"
+)
+
+// validateSpec checks the values of spec that the receive adapter would
+// otherwise refuse to start with.  The CRD schema cannot check durations.
+func validateSpec(spec *v1alpha1.AmqpSourceSpec) error {
+	type duration struct{ field, value string }
+	durations := []duration{{"gracePeriod", spec.GracePeriod}}
+	if b := spec.Batch; b != nil {
+		durations = append(durations, duration{"batch.window", b.Window})
+	}
+	if r := spec.Retry; r != nil {
+		if p := r.JitterPercent; p != nil && (*p < 0 || *p > 100) {
+			return fmt.Errorf("retry.jitterPercent %d is not between 0 and 100", *p)
+		}
+		durations = append(durations,
+			duration{"retry.initialBackoff", r.InitialBackoff},
+			duration{"retry.maxBackoff", r.MaxBackoff},
+			duration{"retry.attemptTimeout", r.AttemptTimeout})
+	}
+	if c := spec.SinkClient; c != nil {
+		durations = append(durations,
+			duration{"sinkClient.timeout", c.Timeout},
+			duration{"sinkClient.idleConnTimeout", c.IdleConnTimeout})
+	}
+	for _, d := range durations {
+		if d.value == "" {
+			continue
+		}
+		v, err := time.ParseDuration(d.value)
+		if err != nil {
+			return fmt.Errorf("%s: %v", d.field, err)
+		}
+		if v < 0 {
+			return fmt.Errorf("%s %q is negative", d.field, d.value)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2018 The Knative Authors
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package amqpsource
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/knative/eventing-sources/pkg/apis/sources/v1alpha1"
+)
+
+func TestValidateSpec(t *testing.T) {
+	jitter := func(p int) *int { return &p }
+	tests := []struct {
+		name    string
+		spec    v1alpha1.AmqpSourceSpec
+		wantErr string
+	}{{
+		name: "defaults",
+	}, {
+		name: "valid durations",
+		spec: v1alpha1.AmqpSourceSpec{
+			GracePeriod: "30s",
+			Batch:       &v1alpha1.AmqpBatchSpec{MaxSize: 10, Window: "50ms"},
+			Retry: &v1alpha1.AmqpRetrySpec{
+				InitialBackoff: "250ms",
+				MaxBackoff:     "1m",
+				JitterPercent:  jitter(0),
+				AttemptTimeout: "5s",
+			},
+			SinkClient: &v1alpha1.AmqpSinkClientSpec{
+				Timeout:         "10s",
+				IdleConnTimeout: "90s",
+			},
+		},
+	}, {
+		name:    "malformed grace period",
+		spec:    v1alpha1.AmqpSourceSpec{GracePeriod: "20"},
+		wantErr: "gracePeriod",
+	}, {
+		name:    "negative batch window",
+		spec:    v1alpha1.AmqpSourceSpec{Batch: &v1alpha1.AmqpBatchSpec{Window: "-1s"}},
+		wantErr: "batch.window",
+	}, {
+		name:    "malformed initial backoff",
+		spec:    v1alpha1.AmqpSourceSpec{Retry: &v1alpha1.AmqpRetrySpec{InitialBackoff: "fast"}},
+		wantErr: "retry.initialBackoff",
+	}, {
+		name:    "negative max backoff",
+		spec:    v1alpha1.AmqpSourceSpec{Retry: &v1alpha1.AmqpRetrySpec{MaxBackoff: "-10s"}},
+		wantErr: "retry.maxBackoff",
+	}, {
+		name:    "malformed attempt timeout",
+		spec:    v1alpha1.AmqpSourceSpec{Retry: &v1alpha1.AmqpRetrySpec{AttemptTimeout: "30 s"}},
+		wantErr: "retry.attemptTimeout",
+	}, {
+		name:    "jitter above 100",
+		spec:    v1alpha1.AmqpSourceSpec{Retry: &v1alpha1.AmqpRetrySpec{JitterPercent: jitter(101)}},
+		wantErr: "retry.jitterPercent",
+	}, {
+		name:    "negative jitter",
+		spec:    v1alpha1.AmqpSourceSpec{Retry: &v1alpha1.AmqpRetrySpec{JitterPercent: jitter(-1)}},
+		wantErr: "retry.jitterPercent",
+	}, {
+		name:    "malformed sink timeout",
+		spec:    v1alpha1.AmqpSourceSpec{SinkClient: &v1alpha1.AmqpSinkClientSpec{Timeout: "1 minute"}},
+		wantErr: "sinkClient.timeout",
+	}, {
+		name:    "negative idle connection timeout",
+		spec:    v1alpha1.AmqpSourceSpec{SinkClient: &v1alpha1.AmqpSinkClientSpec{IdleConnTimeout: "-90s"}},
+		wantErr: "sinkClient.idleConnTimeout",
+	}}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validateSpec(&test.spec)
+			if test.wantErr == "" {
+				if err != nil {
+					t.Errorf("validateSpec() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), test.wantErr) {
+				t.Errorf("validateSpec() error = %v, want an error naming %s", err, test.wantErr)
+			}
+		})
+	}
+}